@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/nobonobo/wrc-pacenote-mod/config"
+)
+
+// ConfigResponse は /api/config の応答。Profile と Settings は起動時に適用した値。
+type ConfigResponse struct {
+	Profile  string            `json:"profile"`
+	File     *config.File      `json:"file"`
+	Settings map[string]string `json:"settings"`
+}
+
+func getConfig(w http.ResponseWriter, r *http.Request) error {
+	f, err := config.LoadFile()
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(ConfigResponse{
+		Profile:  config.Config.Profile,
+		File:     f,
+		Settings: config.Settings(),
+	})
+}
+
+func postConfig(w http.ResponseWriter, r *http.Request) error {
+	f := &config.File{}
+	if err := json.NewDecoder(r.Body).Decode(f); err != nil {
+		return err
+	}
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	log.Println("config save to:", config.FilePath())
+	if err := config.SaveFile(f); err != nil {
+		return err
+	}
+	// 実行中の各処理が設定値を読んでいるのでプロファイルは次の起動時に適用する
+	return json.NewEncoder(w).Encode(Result{true, ""})
+}
+
+func configs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	default:
+		errMsg := http.StatusText(http.StatusMethodNotAllowed)
+		log.Println(errMsg)
+		b, _ := json.Marshal(Result{false, errMsg})
+		http.Error(w, string(b), http.StatusMethodNotAllowed)
+	case "GET":
+		if err := getConfig(w, r); err != nil {
+			log.Println(err)
+			b, _ := json.Marshal(Result{false, err.Error()})
+			http.Error(w, string(b), http.StatusInternalServerError)
+		}
+	case "POST":
+		if err := postConfig(w, r); err != nil {
+			log.Println(err)
+			b, _ := json.Marshal(Result{false, err.Error()})
+			http.Error(w, string(b), http.StatusBadRequest)
+		}
+	}
+}
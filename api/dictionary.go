@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/nobonobo/wrc-pacenote-mod/config"
+)
+
+// DictionaryDir は名前付き辞書のフォルダ
+func DictionaryDir() string {
+	return filepath.Join(config.Config.LogDir, "dictionaries")
+}
+
+// ListDictionaries は名前付き辞書の名前を返す
+func ListDictionaries() ([]string, error) {
+	entries, err := os.ReadDir(DictionaryDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	res := []string{}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".json") {
+			res = append(res, strings.TrimSuffix(e.Name(), ".json"))
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func dictionaries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	names, err := ListDictionaries()
+	if err != nil {
+		log.Println(err)
+		b, _ := json.Marshal(Result{false, err.Error()})
+		http.Error(w, string(b), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(names)
+}
+
+// settingsRequest は省略した項目を変更しない
+type settingsRequest struct {
+	Mode       *Mode   `json:"mode"`
+	Dictionary *string `json:"dictionary"`
+}
+
+func postSettings(w http.ResponseWriter, r *http.Request) error {
+	stage := GetFilePath(r.URL.Path)
+	if stage == "" {
+		return fmt.Errorf("stage not found: %q", r.URL.Path)
+	}
+	var req settingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return err
+	}
+	dir := filepath.Join(config.Config.LogDir, stage)
+	settings, err := LoadStageSettings(dir)
+	if err != nil {
+		return err
+	}
+	if req.Mode != nil {
+		if !req.Mode.Valid() {
+			return fmt.Errorf("invalid mode: %q", *req.Mode)
+		}
+		settings.Mode = *req.Mode
+	}
+	if req.Dictionary != nil {
+		if name := *req.Dictionary; name != "" {
+			names, err := ListDictionaries()
+			if err != nil {
+				return err
+			}
+			i := sort.SearchStrings(names, name)
+			if i >= len(names) || names[i] != name {
+				return fmt.Errorf("dictionary not found: %q", name)
+			}
+		}
+		settings.Dictionary = *req.Dictionary
+	}
+	log.Printf("settings save to: %q %+v", dir, *settings)
+	if err := SaveStageSettings(dir, settings); err != nil {
+		return fmt.Errorf("settings.json save failed: %w", err)
+	}
+	select {
+	case modeChanged <- struct{}{}:
+	default:
+	}
+	return json.NewEncoder(w).Encode(Result{true, ""})
+}
+
+// stageSettings は /api/settings/{location}/{stage}/ で settings.json を読み書きする
+func stageSettings(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	default:
+		errMsg := http.StatusText(http.StatusMethodNotAllowed)
+		log.Println(errMsg)
+		b, _ := json.Marshal(Result{false, errMsg})
+		http.Error(w, string(b), http.StatusMethodNotAllowed)
+	case "GET":
+		if err := getMode(w, r); err != nil {
+			log.Println(err)
+			b, _ := json.Marshal(Result{false, err.Error()})
+			http.Error(w, string(b), http.StatusBadRequest)
+		}
+	case "POST":
+		if err := postSettings(w, r); err != nil {
+			log.Println(err)
+			b, _ := json.Marshal(Result{false, err.Error()})
+			http.Error(w, string(b), http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package api
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/nobonobo/wrc-pacenote-mod/config"
+	"github.com/nobonobo/wrc-pacenote-mod/pacenote"
+)
+
+// stageDictionary はステージで使う名前付き辞書の名前。settings.json に指定が無ければ -dictionary の辞書。
+func stageDictionary(dir string) string {
+	settings, err := LoadStageSettings(dir)
+	if err != nil {
+		log.Println(err)
+	} else if settings.Dictionary != "" {
+		return settings.Dictionary
+	}
+	if f := flag.Lookup("dictionary"); f != nil {
+		return f.Value.String()
+	}
+	return ""
+}
+
+// availableDistances は辞書にある距離の単語(m)。dictionary.json と名前付き辞書で追加した数字も含める。
+func availableDistances(dictionary string) []int {
+	res := append([]int{}, pacenote.Distances...)
+	files := []string{filepath.Join(config.Config.LogDir, "dictionary.json")}
+	if dictionary != "" {
+		files = append(files, filepath.Join(DictionaryDir(), dictionary+".json"))
+	}
+	for _, fpath := range files {
+		b, err := os.ReadFile(fpath)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Println(err)
+			}
+			continue
+		}
+		dict := map[string]json.RawMessage{}
+		if err := json.Unmarshal(b, &dict); err != nil {
+			log.Println(err)
+			continue
+		}
+		for k := range dict {
+			if n, err := strconv.Atoi(k); err == nil && n > 6 {
+				res = append(res, n)
+			}
+		}
+	}
+	sort.Ints(res)
+	return slices.Compact(res)
+}
+
+// pathDistances は samples の先頭からの累積走行距離
+func pathDistances(samples []Sample) []float64 {
+	res := make([]float64, len(samples))
+	for i := 1; i < len(samples); i++ {
+		s, p := samples[i], samples[i-1]
+		res[i] = res[i-1] + math.Sqrt((s.X-p.X)*(s.X-p.X)+(s.Y-p.Y)*(s.Y-p.Y)+(s.Z-p.Z)*(s.Z-p.Z))
+	}
+	return res
+}
+
+// pacenoteSamples は各ペースノートの発火位置に当たるサンプルの位置。位置は経路上を前から順に探す。
+func pacenoteSamples(samples []Sample, pacenotes []Pacenote) []int {
+	res := make([]int, len(pacenotes))
+	j := 0
+	for i, p := range pacenotes {
+		best, bestIdx := math.Inf(1), j
+		for k := j; k < len(samples); k++ {
+			s := samples[k]
+			d := (s.X-p.X)*(s.X-p.X) + (s.Y-p.Y)*(s.Y-p.Y) + (s.Z-p.Z)*(s.Z-p.Z)
+			if d < best {
+				best, bestIdx = d, k
+			}
+			if d < 0.01 {
+				break
+			}
+		}
+		j = bestIdx
+		res[i] = j
+	}
+	return res
+}
+
+// pacenoteDistances は各ペースノートの発火位置の累積走行距離
+func pacenoteDistances(samples []Sample, pacenotes []Pacenote) []float64 {
+	cum := pathDistances(samples)
+	res := make([]float64, len(pacenotes))
+	for i, j := range pacenoteSamples(samples, pacenotes) {
+		if j < len(cum) {
+			res[i] = cum[j]
+		}
+	}
+	return res
+}
+
+// disagrees は書かれた距離が経路上の距離と許容範囲を超えて違うかどうか
+func disagrees(typed int, path float64) bool {
+	return math.Abs(float64(typed)-path) > path*config.Config.DistanceTolerance/100
+}
+
+// AutoDistance は次のペースノートまでの経路上の距離を文言の最後に付ける。
+// mode が correct なら許容範囲を超えて違う距離も書き換える。distances は各ペースノートの累積走行距離。
+// 距離は名前付き辞書 dictionary で読み上げられる値に丸める。
+func AutoDistance(messages []string, distances []float64, mode, dictionary string) ([]string, error) {
+	switch mode {
+	case "", "off":
+		return messages, nil
+	case "append", "correct":
+	default:
+		return nil, fmt.Errorf("invalid auto-distance: %q", mode)
+	}
+	available := availableDistances(dictionary)
+	res := append([]string{}, messages...)
+	for i := 0; i+1 < len(messages); i++ {
+		path := distances[i+1] - distances[i]
+		tokens := pacenote.Tokenize(messages[i])
+		typed, _, ok := pacenote.LastDistance(tokens)
+		if !ok {
+			continue
+		}
+		if typed != 0 && (mode != "correct" || !disagrees(typed, path)) {
+			continue
+		}
+		d := pacenote.RoundDistance(path, available)
+		if d == 0 || d == typed {
+			continue
+		}
+		if typed != 0 {
+			log.Printf("distance corrected: %q %d -> %d (%.0fm)", messages[i], typed, d, path)
+		}
+		res[i] = setDistance(messages[i], tokens, d)
+	}
+	return res, nil
+}
+
+// setDistance は文言の最後の距離だけを d にする。書き方を変えるのは -normalize 指定時のみ。
+func setDistance(message string, tokens []pacenote.Token, d int) string {
+	if config.Config.Normalize {
+		return pacenote.Format(pacenote.SetDistance(tokens, d), pacenote.StyleWords)
+	}
+	text := strings.TrimRightFunc(message, unicode.IsSpace)
+	if _, index, _ := pacenote.LastDistance(tokens); index >= 0 {
+		// 距離は最後の単語
+		text = text[:strings.LastIndexFunc(text, unicode.IsSpace)+1]
+	} else {
+		text += " "
+	}
+	return text + strconv.Itoa(d)
+}
+
+// distanceIssue は書かれた距離が経路と食い違っていれば誤りにする
+func distanceIssue(message string, path float64) (pacenote.Issue, bool) {
+	tokens := pacenote.Tokenize(message)
+	typed, index, _ := pacenote.LastDistance(tokens)
+	if typed == 0 || !disagrees(typed, path) {
+		return pacenote.Issue{}, false
+	}
+	return pacenote.Issue{
+		Pos:     tokens[index].Pos,
+		Token:   tokens[index].Text,
+		Message: fmt.Sprintf("distance differs from the path (%.0fm)", path),
+	}, true
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/nobonobo/wrc-pacenote-mod/config"
+)
+
+func TestPacenoteDistances(t *testing.T) {
+	// 一度通った地点の近くを戻ってくる経路
+	samples := []Sample{{X: 0}, {X: 100}, {X: 100, Z: 50}, {X: 0, Z: 50}, {X: 0, Z: 5}}
+	if got, want := pathDistances(samples), []float64{0, 100, 150, 250, 295}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pathDistances = %v, want %v", got, want)
+	}
+	pacenotes := []Pacenote{{X: 1}, {X: 99}, {X: 1, Z: 5}}
+	if got, want := pacenoteSamples(samples, pacenotes), []int{0, 1, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pacenoteSamples = %v, want %v", got, want)
+	}
+	if got, want := pacenoteDistances(samples, pacenotes), []float64{0, 100, 295}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pacenoteDistances = %v, want %v", got, want)
+	}
+}
+
+func TestAutoDistance(t *testing.T) {
+	logDir := config.Config.LogDir
+	defer func() { config.Config.LogDir = logDir }()
+	config.Config.LogDir = t.TempDir()
+	if err := os.MkdirAll(DictionaryDir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(DictionaryDir(), "long.json"), []byte(`{"1000": {"text": "せん"}, "left": {}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := availableDistances("long"); !slices.Contains(got, 1000) {
+		t.Errorf("availableDistances(long) = %v, want 1000 included", got)
+	}
+	if got := availableDistances(""); slices.Contains(got, 1000) {
+		t.Errorf("availableDistances() = %v, want 1000 excluded", got)
+	}
+
+	messages := []string{"3-left", "4-right 300", "crest into", "6-left 100"}
+	distances := []float64{0, 980, 1100, 1200}
+	tests := []struct {
+		mode, dictionary string
+		want             []string
+	}{
+		{"off", "", messages},
+		{"append", "", messages}, // 980m は辞書の最大 500m の1.5倍を超える
+		{"append", "long", []string{"3-left 1000", "4-right 300", "crest into", "6-left 100"}},
+		{"correct", "", []string{"3-left", "4-right 120", "crest into", "6-left 100"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode+"/"+tt.dictionary, func(t *testing.T) {
+			got, err := AutoDistance(messages, distances, tt.mode, tt.dictionary)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AutoDistance = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	// 書き方は -normalize 指定時のみ変える
+	normalize := config.Config.Normalize
+	defer func() { config.Config.Normalize = normalize }()
+	compact := []string{"3L > 4R+", "SQR  300", "6L"}
+	for _, tt := range []struct {
+		normalize bool
+		want      []string
+	}{
+		{false, []string{"3L > 4R+ 120", "SQR  500", "6L"}},
+		{true, []string{"3-left into 4-right tightens 120", "square-right 500", "6L"}},
+	} {
+		config.Config.Normalize = tt.normalize
+		got, err := AutoDistance(compact, []float64{0, 120, 620}, "correct", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("AutoDistance(normalize=%v) = %q, want %q", tt.normalize, got, tt.want)
+		}
+	}
+	if _, err := AutoDistance(messages, distances, "always", ""); err == nil {
+		t.Error("invalid mode was accepted")
+	}
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Event は /api/events で配信するイベント
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data,omitempty"`
+}
+
+// StageEvent はステージ検出結果
+type StageEvent struct {
+	StageLength float64 `json:"stageLength"`
+	Location    string  `json:"location,omitempty"`
+	Stage       string  `json:"stage,omitempty"`
+	Dir         string  `json:"dir"`
+}
+
+// SessionEvent は記録セッションの状態変化
+type SessionEvent struct {
+	State  string `json:"state"`
+	Dir    string `json:"dir,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// FiredEvent は発火したペースノート
+type FiredEvent struct {
+	Index    int     `json:"index"`
+	Message  string  `json:"message"`
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	Z        float64 `json:"z"`
+	Distance float64 `json:"distance"`
+}
+
+// DeltaEvent はベスト記録とのタイム差
+type DeltaEvent struct {
+	Distance float64 `json:"distance"`
+	Delta    float64 `json:"delta"`
+	Take     int     `json:"take"`
+}
+
+// SpeechEvent はヘッドレスモードで読み上げの代わりに送る文言
+type SpeechEvent struct {
+	Text string `json:"text"`
+}
+
+// ErrorEvent は TTS やキャプチャの失敗
+type ErrorEvent struct {
+	Source  string `json:"source"`
+	Message string `json:"message"`
+}
+
+var (
+	eventsMu    sync.Mutex
+	subscribers = map[chan Event]struct{}{}
+)
+
+// Publish はイベントを購読中の全クライアントに送る。詰まっているクライアントには送らない。
+func Publish(typ string, data any) {
+	ev := Event{Type: typ, Time: time.Now(), Data: data}
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	for ch := range subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+func subscribe() chan Event {
+	ch := make(chan Event, 64)
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	subscribers[ch] = struct{}{}
+	return ch
+}
+
+func unsubscribe(ch chan Event) {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	delete(subscribers, ch)
+}
+
+func events(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	ch := subscribe()
+	defer unsubscribe(ch)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// 接続直後に現在のモードを送る
+	fmt.Fprintf(w, "event: mode\ndata: %s\n\n", mustJSON(Event{Type: "mode", Time: time.Now(), Data: getCurrentMode()}))
+	flusher.Flush()
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case ev := <-ch:
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, mustJSON(ev))
+		}
+		flusher.Flush()
+	}
+}
+
+func mustJSON(v any) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Println(err)
+		return []byte("null")
+	}
+	return b
+}
@@ -0,0 +1,44 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nobonobo/wrc-pacenote-mod/config"
+	"github.com/nobonobo/wrc-pacenote-mod/pack"
+)
+
+// export は /api/export/{location}/ または /api/export/{location}/{stage}/ のパックを返す
+func export(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := func() error {
+		stages, err := pack.Select(r.URL.Path)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if _, err := pack.Export(&buf, config.Config.LogDir, stages); err != nil {
+			return err
+		}
+		name := fmt.Sprintf("pacenotes-%s-%s.zip",
+			strings.ReplaceAll(strings.Trim(r.URL.Path, "/"), "/", "-"),
+			time.Now().Format("20060102"))
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		_, err = w.Write(buf.Bytes())
+		return err
+	}(); err != nil {
+		log.Println(err)
+		w.Header().Set("Content-Type", "application/json")
+		b, _ := json.Marshal(Result{false, err.Error()})
+		http.Error(w, string(b), http.StatusNotFound)
+	}
+}
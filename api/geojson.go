@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/nobonobo/wrc-pacenote-mod/config"
+)
+
+// Feature は GeoJSON の Feature
+type Feature struct {
+	Type       string         `json:"type"`
+	Geometry   Geometry       `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+type Geometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+type FeatureCollection struct {
+	Type     string    `json:"type"`
+	Features []Feature `json:"features"`
+}
+
+// PathPoint は JSON 出力の経路の1点
+type PathPoint struct {
+	X         float64 `json:"x"`
+	Y         float64 `json:"y"`
+	Z         float64 `json:"z"`
+	Time      float64 `json:"time"` // 補正後の音声時刻(秒)
+	StageTime float64 `json:"stageTime"`
+	Distance  float64 `json:"distance"` // 経路に沿った累積距離(m)
+}
+
+// StagePath は JSON 出力の経路とペースノート
+type StagePath struct {
+	Stage     string      `json:"stage"`
+	Path      []PathPoint `json:"path"`
+	Pacenotes []Pacenote  `json:"pacenotes"`
+	samples   []Sample
+}
+
+func loadStagePath(stage string) (*StagePath, error) {
+	samples, _, err := loadAlignedTelemetry(stage)
+	if err != nil {
+		return nil, err
+	}
+	pacenotes, err := LoadPacenotes(filepath.Join(config.Config.LogDir, stage, "pacenote.log"))
+	if err != nil {
+		pacenotes = []Pacenote{}
+	}
+	res := &StagePath{
+		Stage:     filepath.ToSlash(stage),
+		Path:      make([]PathPoint, len(samples)),
+		Pacenotes: pacenotes,
+		samples:   samples,
+	}
+	distances := pathDistances(samples)
+	for i, s := range samples {
+		res.Path[i] = PathPoint{
+			X: s.X, Y: s.Y, Z: s.Z,
+			Time:      s.Time.Seconds(),
+			StageTime: s.StageTime,
+			Distance:  distances[i],
+		}
+	}
+	return res, nil
+}
+
+// GeoJSON は X/Z を平面座標、Y を標高とした FeatureCollection にする
+func (p *StagePath) GeoJSON() *FeatureCollection {
+	coords := make([][3]float64, len(p.Path))
+	times := make([]float64, len(p.Path))
+	stageTimes := make([]float64, len(p.Path))
+	distances := make([]float64, len(p.Path))
+	for i, v := range p.Path {
+		coords[i] = [3]float64{v.X, v.Z, v.Y}
+		times[i] = v.Time
+		stageTimes[i] = v.StageTime
+		distances[i] = v.Distance
+	}
+	fc := &FeatureCollection{Type: "FeatureCollection", Features: []Feature{{
+		Type:     "Feature",
+		Geometry: Geometry{Type: "LineString", Coordinates: coords},
+		Properties: map[string]any{
+			"kind":      "path",
+			"stage":     p.Stage,
+			"time":      times,
+			"stageTime": stageTimes,
+			"distance":  distances,
+		},
+	}}}
+	for i, v := range p.Pacenotes {
+		fc.Features = append(fc.Features, Feature{
+			Type:     "Feature",
+			Geometry: Geometry{Type: "Point", Coordinates: [3]float64{v.X, v.Z, v.Y}},
+			Properties: map[string]any{
+				"kind":    "pacenote",
+				"index":   i,
+				"message": v.Message,
+			},
+		})
+	}
+	return fc
+}
+
+// mapExport は /api/map/{location}/{stage}.geojson と .json を返す
+func mapExport(w http.ResponseWriter, r *http.Request, ext string) {
+	p := strings.TrimSuffix(strings.TrimSuffix(r.URL.Path, ext), "/") + "/"
+	stage := GetFilePath(p)
+	w.Header().Set("Content-Type", "application/json")
+	if err := func() error {
+		if stage == "" {
+			return fmt.Errorf("stage not found: %q", r.URL.Path)
+		}
+		path, err := loadStagePath(stage)
+		if err != nil {
+			return err
+		}
+		if ext == ".geojson" {
+			w.Header().Set("Content-Type", "application/geo+json")
+			return json.NewEncoder(w).Encode(path.GeoJSON())
+		}
+		return json.NewEncoder(w).Encode(path)
+	}(); err != nil {
+		log.Println(err)
+		b, _ := json.Marshal(Result{false, err.Error()})
+		http.Error(w, string(b), http.StatusNotFound)
+	}
+}
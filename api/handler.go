@@ -14,12 +14,10 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	"time"
-
-	svg "github.com/ajstarks/svgo"
 
 	"github.com/nobonobo/wrc-pacenote-mod/config"
 	"github.com/nobonobo/wrc-pacenote-mod/easportswrc"
+	"github.com/nobonobo/wrc-pacenote-mod/pacenote"
 )
 
 type Result struct {
@@ -49,35 +47,15 @@ func GetStageByPath(dir string) *easportswrc.Stage {
 	if err != nil {
 		return nil
 	}
-	locIdx := loc - 1
-	if locIdx < 0 || locIdx >= len(easportswrc.Locations) {
-		return nil
-	}
-	location := easportswrc.Locations[locIdx]
 	ss, err := strconv.Atoi(s)
 	if err != nil {
 		return nil
 	}
-	ssIdx := ss - 1
-	if ssIdx < 0 || ssIdx >= len(location.Stages) {
-		return nil
-	}
-	stage := location.Stages[ssIdx]
-	return &easportswrc.Stage{
-		ID: easportswrc.StageID{
-			Location: loc,
-			Stage:    ss,
-		},
-		Location: location.Name,
-		Stage:    stage,
-	}
+	return easportswrc.GetStageByID(loc, ss)
 }
 
 func GetFilePathFromStage(stage *easportswrc.Stage) string {
-	return filepath.Join(
-		fmt.Sprintf("%02d.%s", stage.ID.Location, stage.Location),
-		fmt.Sprintf("%02d.%s", stage.ID.Stage, stage.Stage),
-	)
+	return stage.Dir()
 }
 
 func GetFilePath(p string) string {
@@ -169,21 +147,15 @@ func files(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, fpath)
 }
 
-func getRegions(w io.Writer, r *http.Request) error {
-	stage := GetFilePath(r.URL.Path)
-	if stage == "" {
-		return fmt.Errorf("stage not found: %q", r.URL.Path)
-	}
-	fpath := filepath.Join(config.Config.LogDir, stage, "regions.log")
-	log.Println("regions load from:", fpath)
+// LoadRegions は regions.log を読み込む。無ければ空。
+func LoadRegions(fpath string) (Regions, error) {
 	regions := Regions{}
 	fp, err := os.Open(fpath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			json.NewEncoder(w).Encode(regions)
-			return nil
+			return regions, nil
 		}
-		return err
+		return nil, err
 	}
 	defer fp.Close()
 	scanner := bufio.NewScanner(fp)
@@ -207,10 +179,26 @@ func getRegions(w io.Writer, r *http.Request) error {
 			Content: fields[2],
 		})
 	}
-	if err := json.NewEncoder(w).Encode(regions); err != nil {
+	return regions, scanner.Err()
+}
+
+// getRegions は /api/regions/{location}/{stage}/?take=1 でテイクの regions.log を返す。take を省略すると無印。
+func getRegions(w io.Writer, r *http.Request) error {
+	stage := GetFilePath(r.URL.Path)
+	if stage == "" {
+		return fmt.Errorf("stage not found: %q", r.URL.Path)
+	}
+	n, err := queryInt(r, "take", 0)
+	if err != nil {
 		return err
 	}
-	return nil
+	fpath := filepath.Join(config.Config.LogDir, stage, "regions.log"+TakeSuffix(n))
+	log.Println("regions load from:", fpath)
+	regions, err := LoadRegions(fpath)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(regions)
 }
 
 func postRegions(w http.ResponseWriter, r *http.Request) error {
@@ -243,46 +231,48 @@ func postRegions(w http.ResponseWriter, r *http.Request) error {
 		return fmt.Errorf("region.log save failed: %w", err)
 	}
 	// generate pacenote
-	fpath := filepath.Join(config.Config.LogDir, stage, "pacenote.log")
-	output, err := os.Create(fpath)
-	if err != nil {
-		return fmt.Errorf("pacenote.log create failed: %w", err)
-	}
-	defer output.Close()
-	fp, err := os.Open(filepath.Join(config.Config.LogDir, stage, "telemetry.log"))
+	samples, _, err := loadAlignedTelemetry(stage)
 	if err != nil {
 		return err
 	}
-	defer fp.Close()
-	scanner := bufio.NewScanner(fp)
+	cum := pathDistances(samples)
+	pacenotes := []Pacenote{}
+	messages := []string{}
+	distances := []float64{}
 	index := 0
-	for scanner.Scan() {
-		text := scanner.Text()
-		fields := strings.Split(text, ",")
-		if len(fields) != 5 {
-			continue
-		}
-		ts, err := strconv.Atoi(fields[1]) // duration
-		if err != nil {
-			return err
-		}
-		pos := []float64{}
-		for i := 0; i < 3; i++ {
-			p, err := strconv.ParseFloat(fields[i+2], 64)
-			if err != nil {
-				return err
-			}
-			pos = append(pos, p)
-		}
+	for i, s := range samples {
 		if index >= len(regions) {
 			break
 		}
 		region := regions[index]
-		if region.Start < float64(ts)/float64(time.Second) {
-			fmt.Fprintf(output, "%f,%f,%f,%s\n", pos[0], pos[1], pos[2], region.Content)
+		if region.Start < s.Time.Seconds() {
+			message := region.Content
+			if config.Config.Normalize {
+				// 略記などは読み上げ用の辞書の単語にする
+				if message, err = pacenote.Normalize(message, pacenote.StyleWords); err != nil {
+					return err
+				}
+			}
+			pacenotes = append(pacenotes, Pacenote{X: s.X, Y: s.Y, Z: s.Z})
+			messages = append(messages, message)
+			distances = append(distances, cum[i])
 			index++
 		}
 	}
+	dictionary := stageDictionary(filepath.Join(config.Config.LogDir, stage))
+	messages, err = AutoDistance(messages, distances, config.Config.AutoDistance, dictionary)
+	if err != nil {
+		return err
+	}
+	fpath := filepath.Join(config.Config.LogDir, stage, "pacenote.log")
+	output, err := os.Create(fpath)
+	if err != nil {
+		return fmt.Errorf("pacenote.log create failed: %w", err)
+	}
+	defer output.Close()
+	for i, p := range pacenotes {
+		fmt.Fprintf(output, "%f,%f,%f,%s\n", p.X, p.Y, p.Z, messages[i])
+	}
 	if err := output.Sync(); err != nil {
 		return fmt.Errorf("pacenote.log save failed: %w", err)
 	}
@@ -317,83 +307,6 @@ func regions(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func mapgen(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "image/svg+xml")
-	stage := GetFilePath(r.URL.Path)
-	fpath := filepath.Join(config.Config.LogDir, stage, "telemetry.log")
-	fp, err := os.Open(fpath)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
-		return
-	}
-	defer fp.Close()
-	log.Println("mapgen serve from:", fpath)
-	listT := []string{}
-	listZ := []int{}
-	listX := []int{}
-	maxZ := -10000000
-	minZ := 10000000
-	maxX := -10000000
-	minX := 10000000
-	scanner := bufio.NewScanner(fp)
-	for scanner.Scan() {
-		text := scanner.Text()
-		fields := strings.Split(text, ",")
-		if len(fields) != 5 {
-			continue
-		}
-		listT = append(listT, fields[1])
-		pos := []float64{}
-		for i := 2; i < 5; i++ {
-			p, err := strconv.ParseFloat(fields[i], 64)
-			if err != nil {
-				log.Print(err)
-				continue
-			}
-			pos = append(pos, p*10)
-		}
-		z, x := int(pos[2]), int(pos[0])
-		if z > maxZ {
-			maxZ = z
-		}
-		if z < minZ {
-			minZ = z
-		}
-		if x > maxX {
-			maxX = x
-		}
-		if x < minX {
-			minX = x
-		}
-		listZ = append(listZ, z)
-		listX = append(listX, x)
-	}
-	canvas := svg.New(w)
-	canvas.StartviewUnit(256, 256, "px", minX-1000, minZ-1000, maxX-minX+2000, maxZ-minZ+2000)
-	canvas.Style("",
-		"line{stroke:cyan;stroke-width:10vh}",
-		"circle{fill:red;stroke:black;stroke-width:10vh}",
-		"text{text-anchor:middle;font-size:200vh;fill:silver}",
-	)
-	canvas.Text((maxX-minX)/2+minX, maxZ+800, strings.Replace(stage, "\\", " / ", -1))
-	canvas.Gid("points")
-	const NA = -99999999
-	lastZ, lastX := NA, NA
-	for i := 0; i < len(listT); i++ {
-		if lastZ != NA && lastX != NA {
-			canvas.Gid(listT[i])
-			canvas.Line(lastX, lastZ, listX[i], listZ[i])
-			canvas.Gend()
-		}
-		lastZ, lastX = listZ[i], listX[i]
-	}
-	canvas.Gend()
-	canvas.Gid("vehicle")
-	canvas.Circle(listX[0], listZ[0], 100)
-	canvas.Gend()
-	canvas.End()
-}
-
 type SpeechRequest struct {
 	Text string `json:"text"`
 }
@@ -423,9 +336,28 @@ func Setup(ctx context.Context, speechCh chan<- string) {
 	http.Handle("/api/", http.StripPrefix("/api", mux))
 	mux.Handle("/hello", http.HandlerFunc(hello))
 	mux.Handle("/speech", speech(speechCh))
+	mux.Handle("/config", http.HandlerFunc(configs))
+	mux.Handle("/install", http.HandlerFunc(install))
+	mux.Handle("/events", http.HandlerFunc(events))
+	mux.Handle("/live", http.HandlerFunc(live))
 	mux.Handle("/locations", http.HandlerFunc(locations))
 	mux.Handle("/stage/", http.StripPrefix("/stage", http.HandlerFunc(stageName)))
 	mux.Handle("/files/", http.StripPrefix("/files", http.HandlerFunc(files)))
 	mux.Handle("/regions/", http.StripPrefix("/regions", http.HandlerFunc(regions)))
 	mux.Handle("/map/", http.StripPrefix("/map", http.HandlerFunc(mapgen)))
+	mux.Handle("/profile/", http.StripPrefix("/profile", http.HandlerFunc(profile)))
+	mux.Handle("/take/", http.StripPrefix("/take", http.HandlerFunc(takes)))
+	mux.Handle("/takes/", http.StripPrefix("/takes", http.HandlerFunc(takeList)))
+	mux.Handle("/export/", http.StripPrefix("/export", http.HandlerFunc(export)))
+	mux.Handle("/times/", http.StripPrefix("/times", http.HandlerFunc(stageTimes)))
+	mux.Handle("/compare/", http.StripPrefix("/compare", http.HandlerFunc(compare)))
+	mux.Handle("/stages/", http.StripPrefix("/stages", http.HandlerFunc(stages)))
+	mux.Handle("/playback/", http.StripPrefix("/playback", http.HandlerFunc(playback)))
+	mux.Handle("/mode", http.HandlerFunc(currentModeHandler))
+	mux.Handle("/mode/", http.StripPrefix("/mode", http.HandlerFunc(mode)))
+	mux.Handle("/settings/", http.StripPrefix("/settings", http.HandlerFunc(stageSettings)))
+	mux.Handle("/dictionaries", http.HandlerFunc(dictionaries))
+	mux.Handle("/parse", http.HandlerFunc(parse))
+	mux.Handle("/check/", http.StripPrefix("/check", http.HandlerFunc(check)))
+	mux.Handle("/render/", http.StripPrefix("/render", http.HandlerFunc(render)))
 }
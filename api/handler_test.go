@@ -0,0 +1,60 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nobonobo/wrc-pacenote-mod/config"
+)
+
+func TestPostRegions(t *testing.T) {
+	saved := config.Config
+	defer func() { config.Config = saved }()
+	config.Config.LogDir = t.TempDir()
+	config.Config.AutoDistance = "off"
+	dir := filepath.Join(config.Config.LogDir, GetFilePath("/1/1/"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	telemetry := ""
+	for i := range 10 {
+		telemetry += fmt.Sprintf("%d,%d,%d,0,0\n", i+1, i*1e9, i*10)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "telemetry.log"), []byte(telemetry), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	body := `[{"start": 2.5, "end": 3, "content": "3L  > 4R+"}, {"start": 0.5, "end": 1, "content": "crest"}]`
+	tests := []struct {
+		normalize bool
+		want      string
+	}{
+		{false, "10.000000,0.000000,0.000000,crest\n30.000000,0.000000,0.000000,3L  > 4R+\n"},
+		{true, "10.000000,0.000000,0.000000,crest\n30.000000,0.000000,0.000000,3-left into 4-right tightens\n"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint("normalize=", tt.normalize), func(t *testing.T) {
+			config.Config.Normalize = tt.normalize
+			w := httptest.NewRecorder()
+			regions(w, httptest.NewRequest(http.MethodPost, "/1/1/", strings.NewReader(body)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", w.Code, w.Body)
+			}
+			b, err := os.ReadFile(filepath.Join(dir, "pacenote.log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("pacenote.log = %q, want %q", b, tt.want)
+			}
+			b, _ = os.ReadFile(filepath.Join(dir, "regions.log"))
+			if !strings.Contains(string(b), "3L  > 4R+") {
+				t.Errorf("regions.log = %q, want the typed text", b)
+			}
+		})
+	}
+}
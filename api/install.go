@@ -0,0 +1,39 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// InstallStatus は voicevox_core のインストール状況
+type InstallStatus struct {
+	Step  string `json:"step"`
+	Done  int64  `json:"done"`
+	Total int64  `json:"total"`
+	Error string `json:"error,omitempty"`
+}
+
+var (
+	installMu     sync.Mutex
+	installStatus = InstallStatus{Step: "pending"}
+)
+
+func SetInstallStatus(s InstallStatus) {
+	installMu.Lock()
+	defer installMu.Unlock()
+	installStatus = s
+	Publish("install", s)
+}
+
+func install(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	installMu.Lock()
+	s := installStatus
+	installMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/nobonobo/wrc-pacenote-mod/config"
+)
+
+// LivePacenote は次に発火する予定のペースノート
+type LivePacenote struct {
+	Index   int     `json:"index"`
+	Message string  `json:"message"`
+	X       float64 `json:"x"`
+	Y       float64 `json:"y"`
+	Z       float64 `json:"z"`
+}
+
+// LiveSample は /api/live で配信する走行中のテレメトリ
+type LiveSample struct {
+	Location    int            `json:"location"` // ロケーション番号(不明なら0)
+	Stage       int            `json:"stage"`    // ステージ番号(不明なら0)
+	StageLength float64        `json:"stageLength"`
+	Distance    float64        `json:"distance"`
+	StageTime   float64        `json:"stageTime"`
+	Speed       float64        `json:"speed"`
+	X           float64        `json:"x"`
+	Y           float64        `json:"y"`
+	Z           float64        `json:"z"`
+	VelocityX   float64        `json:"vx"`
+	VelocityY   float64        `json:"vy"`
+	VelocityZ   float64        `json:"vz"`
+	Upcoming    []LivePacenote `json:"upcoming"`
+}
+
+var (
+	liveMu   sync.Mutex
+	liveSubs = map[chan LiveSample]struct{}{}
+	upgrader = websocket.Upgrader{
+		// 同じサーバーのフロントエンドとローカルのオーバーレイから使う
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+)
+
+// LiveEnabled は /api/live の購読者がいるかどうか
+func LiveEnabled() bool {
+	liveMu.Lock()
+	defer liveMu.Unlock()
+	return len(liveSubs) > 0
+}
+
+// PublishLive はサンプルを購読中の全クライアントに送る。間引きはクライアント毎に行う。
+func PublishLive(s LiveSample) {
+	liveMu.Lock()
+	defer liveMu.Unlock()
+	for ch := range liveSubs {
+		select {
+		case ch <- s:
+		default:
+		}
+	}
+}
+
+func live(w http.ResponseWriter, r *http.Request) {
+	rate := config.Config.LiveRate
+	if v := r.URL.Query().Get("rate"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f > 0 && f < rate {
+			rate = f
+		}
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer conn.Close()
+	ch := make(chan LiveSample, 16)
+	liveMu.Lock()
+	liveSubs[ch] = struct{}{}
+	liveMu.Unlock()
+	defer func() {
+		liveMu.Lock()
+		delete(liveSubs, ch)
+		liveMu.Unlock()
+	}()
+	// クライアントからの切断を検出する
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	interval := time.Duration(0)
+	if rate > 0 {
+		interval = time.Duration(float64(time.Second) / rate)
+	}
+	last := time.Time{}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-closed:
+			return
+		case s := <-ch:
+			now := time.Now()
+			if now.Sub(last) < interval {
+				continue
+			}
+			last = now
+			conn.SetWriteDeadline(now.Add(5 * time.Second))
+			if err := conn.WriteJSON(s); err != nil {
+				log.Println(err)
+				return
+			}
+		}
+	}
+}
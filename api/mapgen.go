@@ -0,0 +1,220 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	svg "github.com/ajstarks/svgo"
+
+	"github.com/nobonobo/wrc-pacenote-mod/config"
+)
+
+// 地図のレイヤー。?layers=pacenotes,endpoints,speed のように指定する。
+const (
+	LayerPacenotes = "pacenotes" // ペースノートの発火位置と文言
+	LayerEndpoints = "endpoints" // スタートとフィニッシュ
+	LayerSpeed     = "speed"     // 経路を速度で色分け
+	LayerCurvature = "curvature" // 経路を曲率で色分け
+)
+
+var defaultLayers = []string{LayerPacenotes, LayerEndpoints}
+
+func mapLayers(r *http.Request) map[string]bool {
+	layers := map[string]bool{}
+	names := defaultLayers
+	if r.URL.Query().Has("layers") {
+		names = strings.Split(r.URL.Query().Get("layers"), ",")
+	}
+	for _, v := range names {
+		if v = strings.TrimSpace(v); v != "" {
+			layers[v] = true
+		}
+	}
+	return layers
+}
+
+// sampleSpeeds は各サンプルに至る区間の速度(m/s)
+func sampleSpeeds(samples []Sample) []float64 {
+	res := make([]float64, len(samples))
+	for i := 1; i < len(samples); i++ {
+		a, b := samples[i-1], samples[i]
+		dt := (b.Time - a.Time).Seconds()
+		if dt <= 0 {
+			res[i] = res[i-1]
+			continue
+		}
+		res[i] = math.Hypot(b.X-a.X, b.Z-a.Z) / dt
+	}
+	if len(res) > 1 {
+		res[0] = res[1]
+	}
+	return res
+}
+
+// sampleCurvatures は各サンプル付近の曲率(1/m)。前後 span m の点から求める。
+func sampleCurvatures(samples []Sample, span float64) []float64 {
+	res := make([]float64, len(samples))
+	for i := range samples {
+		p := samples[i]
+		prev, next := -1, -1
+		for j := i - 1; j >= 0; j-- {
+			if math.Hypot(samples[j].X-p.X, samples[j].Z-p.Z) >= span {
+				prev = j
+				break
+			}
+		}
+		for j := i + 1; j < len(samples); j++ {
+			if math.Hypot(samples[j].X-p.X, samples[j].Z-p.Z) >= span {
+				next = j
+				break
+			}
+		}
+		if prev < 0 || next < 0 {
+			continue
+		}
+		a, c := samples[prev], samples[next]
+		// 3点を通る円の半径の逆数
+		ab := math.Hypot(p.X-a.X, p.Z-a.Z)
+		bc := math.Hypot(c.X-p.X, c.Z-p.Z)
+		ca := math.Hypot(a.X-c.X, a.Z-c.Z)
+		cross := (p.X-a.X)*(c.Z-a.Z) - (p.Z-a.Z)*(c.X-a.X)
+		res[i] = 2 * math.Abs(cross) / (ab * bc * ca)
+	}
+	return res
+}
+
+// heatColor は 0..1 の値を青→緑→赤の色にする
+func heatColor(v float64) string {
+	v = math.Max(0, math.Min(1, v))
+	hue := (1 - v) * 240
+	return fmt.Sprintf("hsl(%d,100%%,50%%)", int(hue))
+}
+
+func mapgen(w http.ResponseWriter, r *http.Request) {
+	for _, ext := range []string{".geojson", ".json"} {
+		if strings.HasSuffix(r.URL.Path, ext) {
+			mapExport(w, r, ext)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	stage := GetFilePath(r.URL.Path)
+	samples, _, err := loadAlignedTelemetry(stage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if len(samples) == 0 {
+		http.Error(w, "telemetry is empty", http.StatusNotFound)
+		return
+	}
+	layers := mapLayers(r)
+	dir := filepath.Join(config.Config.LogDir, stage)
+	log.Println("mapgen serve from:", dir)
+	listT := []string{}
+	listZ := []int{}
+	listX := []int{}
+	maxZ := -10000000
+	minZ := 10000000
+	maxX := -10000000
+	minX := 10000000
+	for _, s := range samples {
+		listT = append(listT, strconv.FormatInt(int64(s.Time), 10))
+		z, x := int(s.Z*10), int(s.X*10)
+		if z > maxZ {
+			maxZ = z
+		}
+		if z < minZ {
+			minZ = z
+		}
+		if x > maxX {
+			maxX = x
+		}
+		if x < minX {
+			minX = x
+		}
+		listZ = append(listZ, z)
+		listX = append(listX, x)
+	}
+	// 経路の色分け
+	colors := make([]string, len(samples))
+	switch {
+	case layers[LayerSpeed]:
+		speeds := sampleSpeeds(samples)
+		max := 0.0
+		for _, v := range speeds {
+			max = math.Max(max, v)
+		}
+		for i, v := range speeds {
+			if max > 0 {
+				colors[i] = heatColor(v / max)
+			}
+		}
+	case layers[LayerCurvature]:
+		// 半径20m以下のコーナーを最大とする
+		for i, v := range sampleCurvatures(samples, 10) {
+			colors[i] = heatColor(v * 20)
+		}
+	}
+	canvas := svg.New(w)
+	canvas.StartviewUnit(256, 256, "px", minX-1000, minZ-1000, maxX-minX+2000, maxZ-minZ+2000)
+	canvas.Style("",
+		"line{stroke:cyan;stroke-width:10vh}",
+		"circle{fill:red;stroke:black;stroke-width:10vh}",
+		"text{text-anchor:middle;font-size:200vh;fill:silver}",
+		"#pacenotes circle{fill:yellow}",
+		"#pacenotes text{font-size:120vh;fill:yellow;text-anchor:start}",
+		"#endpoints text{fill:white}",
+	)
+	canvas.Text((maxX-minX)/2+minX, maxZ+800, strings.Replace(stage, "\\", " / ", -1))
+	canvas.Gid("points")
+	const NA = -99999999
+	lastZ, lastX := NA, NA
+	for i := 0; i < len(listT); i++ {
+		if lastZ != NA && lastX != NA {
+			canvas.Gid(listT[i])
+			if colors[i] != "" {
+				canvas.Line(lastX, lastZ, listX[i], listZ[i], "stroke:"+colors[i])
+			} else {
+				canvas.Line(lastX, lastZ, listX[i], listZ[i])
+			}
+			canvas.Gend()
+		}
+		lastZ, lastX = listZ[i], listX[i]
+	}
+	canvas.Gend()
+	if layers[LayerEndpoints] {
+		last := len(listT) - 1
+		canvas.Gid("endpoints")
+		canvas.Circle(listX[0], listZ[0], 150, "fill:lime")
+		canvas.Text(listX[0], listZ[0]-250, "START")
+		canvas.Circle(listX[last], listZ[last], 150, "fill:white")
+		canvas.Text(listX[last], listZ[last]-250, "FINISH")
+		canvas.Gend()
+	}
+	if layers[LayerPacenotes] {
+		pacenotes, err := LoadPacenotes(filepath.Join(dir, "pacenote.log"))
+		if err != nil {
+			log.Println(err)
+		}
+		canvas.Gid("pacenotes")
+		for i, p := range pacenotes {
+			x, z := int(p.X*10), int(p.Z*10)
+			canvas.Group(fmt.Sprintf(`id="pacenote-%d"`, i))
+			canvas.Title(fmt.Sprintf("%d: %s", i, p.Message))
+			canvas.Circle(x, z, 60)
+			canvas.Text(x+100, z+40, p.Message)
+			canvas.Gend()
+		}
+		canvas.Gend()
+	}
+	canvas.Gid("vehicle")
+	canvas.Circle(listX[0], listZ[0], 100)
+	canvas.Gend()
+	canvas.End()
+}
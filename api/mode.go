@@ -0,0 +1,205 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/nobonobo/wrc-pacenote-mod/config"
+)
+
+// Mode はステージ走行時の動作モード
+type Mode string
+
+const (
+	ModeAuto               Mode = "auto" // pacenote.log があれば再生、なければ記録
+	ModeRecord             Mode = "record"
+	ModePlay               Mode = "play"
+	ModeRecordWhilePlaying Mode = "record-while-playing"
+	ModeOff                Mode = "off"
+)
+
+func (m Mode) Valid() bool {
+	switch m {
+	case ModeAuto, ModeRecord, ModePlay, ModeRecordWhilePlaying, ModeOff:
+		return true
+	}
+	return false
+}
+
+// Speech はモード切り替え時に読み上げる単語
+func (m Mode) Speech() string {
+	switch m {
+	case ModeRecord:
+		return "recording-mode"
+	case ModePlay:
+		return "playback-mode"
+	case ModeRecordWhilePlaying:
+		return "record-while-playing-mode"
+	case ModeOff:
+		return "off-mode"
+	}
+	return ""
+}
+
+// StageSettings はステージ毎の設定(settings.json)
+type StageSettings struct {
+	Mode       Mode   `json:"mode,omitempty"`
+	Dictionary string `json:"dictionary,omitempty"` // 名前付き辞書(空なら -dictionary の辞書)
+}
+
+func LoadStageSettings(dir string) (*StageSettings, error) {
+	settings := &StageSettings{}
+	b, err := os.ReadFile(filepath.Join(dir, "settings.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return settings, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+func SaveStageSettings(dir string, settings *StageSettings) error {
+	b, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "settings.json"), b, 0o644)
+}
+
+// CurrentMode は走行中のステージと適用中のモード
+type CurrentMode struct {
+	Stage string `json:"stage"`
+	Mode  Mode   `json:"mode"`
+}
+
+var (
+	currentMu   sync.Mutex
+	current     CurrentMode
+	modeChanged = make(chan struct{}, 1)
+)
+
+// SetCurrentMode は受信側が適用したモードを記録する
+func SetCurrentMode(dir string, mode Mode) {
+	if rel, err := filepath.Rel(config.Config.LogDir, dir); err == nil {
+		dir = rel
+	}
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	current = CurrentMode{Stage: filepath.ToSlash(dir), Mode: mode}
+	Publish("mode", current)
+}
+
+func getCurrentMode() CurrentMode {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	return current
+}
+
+// ModeChanged は API 経由でモード設定が変わったことを通知する
+func ModeChanged() <-chan struct{} {
+	return modeChanged
+}
+
+func currentModeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	case "GET":
+		json.NewEncoder(w).Encode(getCurrentMode())
+	case "POST":
+		cur := getCurrentMode()
+		if cur.Stage == "" {
+			b, _ := json.Marshal(Result{false, "no stage is running"})
+			http.Error(w, string(b), http.StatusBadRequest)
+			return
+		}
+		if err := saveMode(w, r, filepath.Join(config.Config.LogDir, filepath.FromSlash(cur.Stage))); err != nil {
+			log.Println(err)
+			b, _ := json.Marshal(Result{false, err.Error()})
+			http.Error(w, string(b), http.StatusBadRequest)
+		}
+	}
+}
+
+func getMode(w http.ResponseWriter, r *http.Request) error {
+	stage := GetFilePath(r.URL.Path)
+	if stage == "" {
+		return fmt.Errorf("stage not found: %q", r.URL.Path)
+	}
+	settings, err := LoadStageSettings(filepath.Join(config.Config.LogDir, stage))
+	if err != nil {
+		return err
+	}
+	if settings.Mode == "" {
+		settings.Mode = ModeAuto
+	}
+	return json.NewEncoder(w).Encode(settings)
+}
+
+func postMode(w http.ResponseWriter, r *http.Request) error {
+	stage := GetFilePath(r.URL.Path)
+	if stage == "" {
+		return fmt.Errorf("stage not found: %q", r.URL.Path)
+	}
+	return saveMode(w, r, filepath.Join(config.Config.LogDir, stage))
+}
+
+func saveMode(w http.ResponseWriter, r *http.Request, dir string) error {
+	var req StageSettings
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return err
+	}
+	if !req.Mode.Valid() {
+		return fmt.Errorf("invalid mode: %q", req.Mode)
+	}
+	settings, err := LoadStageSettings(dir)
+	if err != nil {
+		return err
+	}
+	settings.Mode = req.Mode
+	log.Printf("mode save to: %q mode=%s", dir, settings.Mode)
+	if err := SaveStageSettings(dir, settings); err != nil {
+		return fmt.Errorf("settings.json save failed: %w", err)
+	}
+	select {
+	case modeChanged <- struct{}{}:
+	default:
+	}
+	return json.NewEncoder(w).Encode(Result{true, ""})
+}
+
+func mode(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	default:
+		errMsg := http.StatusText(http.StatusMethodNotAllowed)
+		log.Println(errMsg)
+		b, _ := json.Marshal(Result{false, errMsg})
+		http.Error(w, string(b), http.StatusMethodNotAllowed)
+	case "GET":
+		if err := getMode(w, r); err != nil {
+			log.Println(err)
+			b, _ := json.Marshal(Result{false, err.Error()})
+			http.Error(w, string(b), http.StatusBadRequest)
+		}
+	case "POST":
+		if err := postMode(w, r); err != nil {
+			log.Println(err)
+			b, _ := json.Marshal(Result{false, err.Error()})
+			http.Error(w, string(b), http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Pacenote は pacenote.log の1行分
+type Pacenote struct {
+	Message string  `json:"message"`
+	X       float64 `json:"x"`
+	Y       float64 `json:"y"`
+	Z       float64 `json:"z"`
+}
+
+// LoadPacenotes は pacenote.log を読み込む
+func LoadPacenotes(fpath string) ([]Pacenote, error) {
+	fp, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	pacenotes := []Pacenote{}
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ",")
+		if len(fields) < 3 {
+			continue
+		}
+		pos := [3]float64{}
+		valid := true
+		for i := range pos {
+			p, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				log.Println(err)
+				valid = false
+				break
+			}
+			pos[i] = p
+		}
+		if !valid {
+			continue
+		}
+		pacenotes = append(pacenotes, Pacenote{
+			Message: strings.Join(fields[3:], " "),
+			X:       pos[0], Y: pos[1], Z: pos[2],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return pacenotes, nil
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/nobonobo/wrc-pacenote-mod/config"
+	"github.com/nobonobo/wrc-pacenote-mod/pacenote"
+)
+
+// ParseResult は /api/parse の結果
+type ParseResult struct {
+	*pacenote.Note
+	Text string `json:"text"` // style で書き直した文言
+}
+
+// parse は /api/parse?text=3L+%20>%204R&style=words で文言を解析する
+func parse(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := func() error {
+		style := pacenote.Style(r.URL.Query().Get("style"))
+		if style == "" {
+			style = pacenote.StyleWords
+		}
+		text := r.URL.Query().Get("text")
+		normalized, err := pacenote.Normalize(text, style)
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(ParseResult{Note: pacenote.Parse(text), Text: normalized})
+	}(); err != nil {
+		log.Println(err)
+		b, _ := json.Marshal(Result{false, err.Error()})
+		http.Error(w, string(b), http.StatusBadRequest)
+	}
+}
+
+// CheckResult は誤りのあるペースノート1行分
+type CheckResult struct {
+	Index   int              `json:"index"`
+	Message string           `json:"message"`
+	Issues  []pacenote.Issue `json:"issues"`
+}
+
+// CheckPacenotes はステージの pacenote.log から誤りのある行を探す。
+// テレメトリがあれば経路上の距離と食い違う距離も誤りにする。
+func CheckPacenotes(stage string) ([]CheckResult, error) {
+	res := []CheckResult{}
+	pacenotes, err := LoadPacenotes(filepath.Join(config.Config.LogDir, stage, "pacenote.log"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return res, nil
+		}
+		return nil, err
+	}
+	var distances []float64
+	if samples, _, err := loadAlignedTelemetry(stage); err == nil {
+		distances = pacenoteDistances(samples, pacenotes)
+	}
+	for i, p := range pacenotes {
+		n := pacenote.Parse(p.Message)
+		if distances != nil && i+1 < len(pacenotes) {
+			if issue, ok := distanceIssue(p.Message, distances[i+1]-distances[i]); ok {
+				n.Issues = append(n.Issues, issue)
+			}
+		}
+		if !n.Valid() {
+			res = append(res, CheckResult{Index: i, Message: p.Message, Issues: n.Issues})
+		}
+	}
+	return res, nil
+}
+
+func check(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := func() error {
+		stage := GetFilePath(r.URL.Path)
+		if stage == "" {
+			return fmt.Errorf("stage not found: %q", r.URL.Path)
+		}
+		res, err := CheckPacenotes(stage)
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(res)
+	}(); err != nil {
+		log.Println(err)
+		b, _ := json.Marshal(Result{false, err.Error()})
+		http.Error(w, string(b), http.StatusNotFound)
+	}
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nobonobo/wrc-pacenote-mod/config"
+)
+
+// Fired は playback.log の1行分(再生中に発火したペースノート)
+type Fired struct {
+	Index     int     `json:"index"`
+	X         float64 `json:"x"`
+	Y         float64 `json:"y"`
+	Z         float64 `json:"z"`
+	VelocityX float64 `json:"vx"`
+	VelocityY float64 `json:"vy"`
+	VelocityZ float64 `json:"vz"`
+	Speed     float64 `json:"speed"`
+	Distance  float64 `json:"distance"`
+	StageTime float64 `json:"stageTime"`
+	Message   string  `json:"message"`
+}
+
+func LoadFired(fpath string) ([]Fired, error) {
+	fp, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	list := []Fired{}
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ",")
+		if len(fields) < 11 {
+			continue
+		}
+		index, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		values := [9]float64{}
+		valid := true
+		for i := range values {
+			v, err := strconv.ParseFloat(fields[i+1], 64)
+			if err != nil {
+				valid = false
+				break
+			}
+			values[i] = v
+		}
+		if !valid {
+			continue
+		}
+		list = append(list, Fired{
+			Index: index,
+			X:     values[0], Y: values[1], Z: values[2],
+			VelocityX: values[3], VelocityY: values[4], VelocityZ: values[5],
+			Speed:     values[6],
+			Distance:  values[7],
+			StageTime: values[8],
+			Message:   strings.Join(fields[10:], " "),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Trigger はペースノート毎の想定発火位置と実際の発火位置の比較
+type Trigger struct {
+	Index    int      `json:"index"`
+	Message  string   `json:"message"`
+	Intended Pacenote `json:"intended"`
+	Actual   *Fired   `json:"actual,omitempty"`
+	// Error は想定位置と実際の発火位置の距離(m)
+	Error float64 `json:"error"`
+	// Lead は進行方向に沿った差(m)、負なら想定位置より手前で発火した
+	Lead float64 `json:"lead"`
+}
+
+func compareTriggers(pacenotes []Pacenote, fired []Fired) []Trigger {
+	res := make([]Trigger, len(pacenotes))
+	for i, p := range pacenotes {
+		res[i] = Trigger{Index: i, Message: p.Message, Intended: p}
+	}
+	for _, f := range fired {
+		if f.Index < 0 || f.Index >= len(res) {
+			continue
+		}
+		t := &res[f.Index]
+		t.Actual = &f
+		dx, dy, dz := f.X-t.Intended.X, f.Y-t.Intended.Y, f.Z-t.Intended.Z
+		t.Error = math.Sqrt(dx*dx + dy*dy + dz*dz)
+		if v := math.Sqrt(f.VelocityX*f.VelocityX + f.VelocityY*f.VelocityY + f.VelocityZ*f.VelocityZ); v > 0 {
+			t.Lead = (dx*f.VelocityX + dy*f.VelocityY + dz*f.VelocityZ) / v
+		}
+	}
+	return res
+}
+
+// latestPlayback は playback.log のある最新のテイク番号
+func latestPlayback(dir string) (int, error) {
+	takes := ListTakes(dir)
+	for i := len(takes) - 1; i >= 0; i-- {
+		if _, err := os.Stat(filepath.Join(dir, "playback.log"+TakeSuffix(takes[i]))); err == nil {
+			return takes[i], nil
+		}
+	}
+	return 0, fmt.Errorf("playback.log not found: %q", dir)
+}
+
+// playback は /api/playback/{location}/{stage}/?take=1 でテイクの想定発火位置と実際の発火位置を比べる。
+// take を省略すると最新の走行を使う。
+func playback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := func() error {
+		stage := GetFilePath(r.URL.Path)
+		if stage == "" {
+			return fmt.Errorf("stage not found: %q", r.URL.Path)
+		}
+		dir := filepath.Join(config.Config.LogDir, stage)
+		pacenotes, err := LoadPacenotes(filepath.Join(dir, "pacenote.log"))
+		if err != nil {
+			return err
+		}
+		n, err := queryInt(r, "take", -1)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			if n, err = latestPlayback(dir); err != nil {
+				return err
+			}
+		}
+		fired, err := LoadFired(filepath.Join(dir, "playback.log"+TakeSuffix(n)))
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(compareTriggers(pacenotes, fired))
+	}(); err != nil {
+		log.Println(err)
+		b, _ := json.Marshal(Result{false, err.Error()})
+		http.Error(w, string(b), http.StatusNotFound)
+	}
+}
@@ -0,0 +1,23 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLatestPlayback(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := latestPlayback(dir); err == nil {
+		t.Error("empty stage has a playback log")
+	}
+	for _, name := range []string{"telemetry.log", "telemetry.log.1", "playback.log.1", "telemetry.log.2", "playback.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// テイク2 は記録モードの走行なので playback.log.2 が無い
+	if n, err := latestPlayback(dir); err != nil || n != 1 {
+		t.Errorf("latestPlayback = %d, %v, want 1", n, err)
+	}
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+
+	svg "github.com/ajstarks/svgo"
+)
+
+// ProfilePoint は標高プロファイルの1点
+type ProfilePoint struct {
+	Distance  float64 `json:"distance"`
+	Elevation float64 `json:"elevation"`
+}
+
+// Extremum は検出したクレスト(頂点)とディップ(底)
+type Extremum struct {
+	Kind       string  `json:"kind"` // crest または dip
+	Distance   float64 `json:"distance"`
+	Elevation  float64 `json:"elevation"`
+	Prominence float64 `json:"prominence"` // 前後の反対側の極値との高低差(m)
+	// GradeChange は前後 gradeSpan m の勾配の差(%)、大きいほど急な頂点・底
+	GradeChange float64 `json:"gradeChange"`
+}
+
+// ProfilePacenote はプロファイル上のペースノート位置
+type ProfilePacenote struct {
+	Index     int     `json:"index"`
+	Message   string  `json:"message"`
+	Distance  float64 `json:"distance"`
+	Elevation float64 `json:"elevation"`
+}
+
+type Profile struct {
+	Stage     string            `json:"stage"`
+	Points    []ProfilePoint    `json:"points"`
+	Extrema   []Extremum        `json:"extrema"`
+	Pacenotes []ProfilePacenote `json:"pacenotes"`
+}
+
+const (
+	profileStep     = 5.0  // プロファイルの距離間隔(m)
+	profileSmooth   = 15.0 // 標高の平滑化幅(m)
+	extremumMinDrop = 1.5  // 極値とみなす最小の高低差(m)
+	gradeSpan       = 25.0
+)
+
+// resample は経路を距離間隔 step で標高を線形補間する
+func resample(path []PathPoint, step float64) []ProfilePoint {
+	if len(path) == 0 {
+		return nil
+	}
+	res := []ProfilePoint{}
+	j := 0
+	end := path[len(path)-1].Distance
+	for d := 0.0; d <= end; d += step {
+		for j+1 < len(path) && path[j+1].Distance < d {
+			j++
+		}
+		e := path[j].Y
+		if j+1 < len(path) {
+			a, b := path[j], path[j+1]
+			if span := b.Distance - a.Distance; span > 0 {
+				e = a.Y + (b.Y-a.Y)*(d-a.Distance)/span
+			}
+		}
+		res = append(res, ProfilePoint{Distance: d, Elevation: e})
+	}
+	return res
+}
+
+// smooth は移動平均で標高の揺れを取り除く
+func smooth(points []ProfilePoint, width float64) []ProfilePoint {
+	n := int(width / profileStep / 2)
+	res := make([]ProfilePoint, len(points))
+	for i := range points {
+		sum, cnt := 0.0, 0
+		for k := max(0, i-n); k <= min(len(points)-1, i+n); k++ {
+			sum += points[k].Elevation
+			cnt++
+		}
+		res[i] = ProfilePoint{Distance: points[i].Distance, Elevation: sum / float64(cnt)}
+	}
+	return res
+}
+
+func gradeAt(points []ProfilePoint, i, offset int) float64 {
+	j := min(max(0, i+offset), len(points)-1)
+	if j == i {
+		return 0
+	}
+	a, b := points[min(i, j)], points[max(i, j)]
+	return (b.Elevation - a.Elevation) / (b.Distance - a.Distance) * 100
+}
+
+// detectExtrema は高低差 minDrop 以上で折り返す頂点と底を探す
+func detectExtrema(points []ProfilePoint, minDrop float64) []Extremum {
+	res := []Extremum{}
+	if len(points) == 0 {
+		return res
+	}
+	span := int(gradeSpan / profileStep)
+	add := func(kind string, i int, prominence float64) {
+		res = append(res, Extremum{
+			Kind:        kind,
+			Distance:    points[i].Distance,
+			Elevation:   points[i].Elevation,
+			Prominence:  prominence,
+			GradeChange: math.Abs(gradeAt(points, i, span) - gradeAt(points, i, -span)),
+		})
+	}
+	// 0: 未定, 1: 上り中(頂点候補を追跡), -1: 下り中(底候補を追跡)
+	dir := 0
+	cand, low, high := 0, 0, 0
+	lastTurn := points[0].Elevation
+	for i, p := range points {
+		switch dir {
+		case 0:
+			if p.Elevation > points[high].Elevation {
+				high = i
+			}
+			if p.Elevation < points[low].Elevation {
+				low = i
+			}
+			if points[high].Elevation-points[low].Elevation >= minDrop {
+				if high > low {
+					dir, cand, lastTurn = 1, high, points[low].Elevation
+				} else {
+					dir, cand, lastTurn = -1, low, points[high].Elevation
+				}
+			}
+		case 1:
+			if p.Elevation > points[cand].Elevation {
+				cand = i
+			} else if points[cand].Elevation-p.Elevation >= minDrop {
+				add("crest", cand, points[cand].Elevation-lastTurn)
+				lastTurn = points[cand].Elevation
+				dir, cand = -1, i
+			}
+		case -1:
+			if p.Elevation < points[cand].Elevation {
+				cand = i
+			} else if p.Elevation-points[cand].Elevation >= minDrop {
+				add("dip", cand, lastTurn-points[cand].Elevation)
+				lastTurn = points[cand].Elevation
+				dir, cand = 1, i
+			}
+		}
+	}
+	return res
+}
+
+func loadProfile(stage string) (*Profile, error) {
+	path, err := loadStagePath(stage)
+	if err != nil {
+		return nil, err
+	}
+	if len(path.Path) == 0 {
+		return nil, fmt.Errorf("telemetry is empty")
+	}
+	points := smooth(resample(path.Path, profileStep), profileSmooth)
+	res := &Profile{
+		Stage:     path.Stage,
+		Points:    points,
+		Extrema:   detectExtrema(points, extremumMinDrop),
+		Pacenotes: []ProfilePacenote{},
+	}
+	for i, j := range pacenoteSamples(path.samples, path.Pacenotes) {
+		p := path.Path[j]
+		res.Pacenotes = append(res.Pacenotes, ProfilePacenote{Index: i, Message: path.Pacenotes[i].Message, Distance: p.Distance, Elevation: p.Y})
+	}
+	return res, nil
+}
+
+func (p *Profile) render(w http.ResponseWriter) {
+	const width, height, margin = 2000, 400, 40
+	minE, maxE := math.Inf(1), math.Inf(-1)
+	for _, v := range p.Points {
+		minE = math.Min(minE, v.Elevation)
+		maxE = math.Max(maxE, v.Elevation)
+	}
+	if maxE-minE < 10 {
+		maxE = minE + 10
+	}
+	length := p.Points[len(p.Points)-1].Distance
+	if length <= 0 {
+		length = 1
+	}
+	px := func(d float64) int { return margin + int(d/length*(width-2*margin)) }
+	py := func(e float64) int { return height - margin - int((e-minE)/(maxE-minE)*(height-2*margin)) }
+	canvas := svg.New(w)
+	canvas.Start(width, height)
+	canvas.Style("",
+		"polyline{fill:none;stroke:cyan;stroke-width:2}",
+		"text{font-size:14px;fill:silver}",
+		".crest{fill:orange}",
+		".dip{fill:deepskyblue}",
+		"#pacenotes line{stroke:yellow;stroke-width:1;stroke-dasharray:4}",
+		"#pacenotes text{fill:yellow;font-size:12px}",
+	)
+	canvas.Text(margin, 20, strings.Replace(p.Stage, "/", " / ", -1))
+	canvas.Text(margin, height-10, fmt.Sprintf("%.0fm - %.0fm / %.0fm", minE, maxE, length))
+	xs, ys := make([]int, len(p.Points)), make([]int, len(p.Points))
+	for i, v := range p.Points {
+		xs[i], ys[i] = px(v.Distance), py(v.Elevation)
+	}
+	canvas.Polyline(xs, ys)
+	canvas.Gid("extrema")
+	for _, e := range p.Extrema {
+		canvas.Circle(px(e.Distance), py(e.Elevation), 5, `class="`+e.Kind+`"`)
+	}
+	canvas.Gend()
+	canvas.Gid("pacenotes")
+	for i, n := range p.Pacenotes {
+		x := px(n.Distance)
+		canvas.Line(x, margin, x, height-margin)
+		canvas.Text(x+2, margin+12+(i%4)*14, n.Message)
+	}
+	canvas.Gend()
+	canvas.End()
+}
+
+// profile は /api/profile/{location}/{stage}/ で SVG、.json で JSON を返す
+func profile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	asJSON := strings.HasSuffix(r.URL.Path, ".json")
+	p := strings.TrimSuffix(strings.TrimSuffix(r.URL.Path, ".json"), "/") + "/"
+	if err := func() error {
+		stage := GetFilePath(p)
+		if stage == "" {
+			return fmt.Errorf("stage not found: %q", r.URL.Path)
+		}
+		prof, err := loadProfile(stage)
+		if err != nil {
+			return err
+		}
+		if asJSON {
+			w.Header().Set("Content-Type", "application/json")
+			return json.NewEncoder(w).Encode(prof)
+		}
+		w.Header().Set("Content-Type", "image/svg+xml")
+		prof.render(w)
+		return nil
+	}(); err != nil {
+		log.Println(err)
+		b, _ := json.Marshal(Result{false, err.Error()})
+		http.Error(w, string(b), http.StatusNotFound)
+	}
+}
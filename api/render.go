@@ -0,0 +1,187 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/moutend/go-wav"
+	"github.com/nobonobo/wrc-pacenote-mod/capture"
+	"github.com/nobonobo/wrc-pacenote-mod/config"
+)
+
+// Renderer は文言を名前付き辞書で合成してモノラル16bit のサンプルを返す
+type Renderer func(ctx context.Context, dictionary, text string) ([]int16, error)
+
+var (
+	rendererMu sync.Mutex
+	renderer   Renderer
+	renderRate int
+)
+
+// SetRenderer は /api/render で使う音声合成とそのサンプルレートを設定する
+func SetRenderer(r Renderer, rate int) {
+	rendererMu.Lock()
+	defer rendererMu.Unlock()
+	renderer = r
+	renderRate = rate
+}
+
+func getRenderer() (Renderer, int) {
+	rendererMu.Lock()
+	defer rendererMu.Unlock()
+	return renderer, renderRate
+}
+
+// loadCapture は capture.wav をモノラルにして rate に変換する
+func loadCapture(fpath string, rate int) ([]int16, error) {
+	b, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+	f := &wav.File{}
+	if err := wav.Unmarshal(b, f); err != nil {
+		return nil, err
+	}
+	channels := f.Channels()
+	if channels < 1 || f.SamplesPerSec() <= 0 {
+		return nil, fmt.Errorf("invalid wav format: %s", fpath)
+	}
+	var values []float64
+	if capture.IsFloat(b) {
+		if f.BitsPerSample() != 32 {
+			return nil, fmt.Errorf("unsupported float format: %d bit: %s", f.BitsPerSample(), fpath)
+		}
+		values = make([]float64, len(f.Bytes())/4)
+		for i := range values {
+			values[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(f.Bytes()[i*4:])))
+		}
+	} else {
+		values = f.Float64s()
+	}
+	frames := len(values) / channels
+	n := int(int64(frames) * int64(rate) / int64(f.SamplesPerSec()))
+	res := make([]int16, n)
+	for i := range res {
+		j := int(int64(i) * int64(f.SamplesPerSec()) / int64(rate))
+		sum := 0.0
+		for c := 0; c < channels; c++ {
+			sum += values[j*channels+c]
+		}
+		res[i] = int16(math.Max(-1, math.Min(1, sum/float64(channels))) * math.MaxInt16)
+	}
+	return res, nil
+}
+
+// RenderStage はステージのペースノートを記録時の発火時刻に並べて合成する。
+// 読み上げ中に次のペースノートが発火した場合は走行中と同じく読み終わるまで待つ。
+// withCapture なら右チャンネルに capture.wav を入れたステレオにする。
+func RenderStage(ctx context.Context, stage string, withCapture bool) ([]byte, error) {
+	synth, rate := getRenderer()
+	if synth == nil {
+		return nil, fmt.Errorf("speech engine is not ready")
+	}
+	dir := filepath.Join(config.Config.LogDir, stage)
+	pacenotes, err := LoadPacenotes(filepath.Join(dir, "pacenote.log"))
+	if err != nil {
+		return nil, err
+	}
+	samples, _, err := loadAlignedTelemetry(stage)
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("telemetry is empty")
+	}
+	settings, err := LoadStageSettings(dir)
+	if err != nil {
+		return nil, err
+	}
+	var recorded []int16
+	if withCapture {
+		if recorded, err = loadCapture(filepath.Join(dir, "capture.wav"), rate); err != nil {
+			return nil, err
+		}
+	}
+	voice := make([]int16, len(recorded))
+	cache := map[string][]int16{}
+	end := 0
+	for i, idx := range pacenoteSamples(samples, pacenotes) {
+		message := pacenotes[i].Message
+		v, ok := cache[message]
+		if !ok {
+			if v, err = synth(ctx, settings.Dictionary, message); err != nil {
+				return nil, err
+			}
+			cache[message] = v
+		}
+		start := max(int(samples[idx].Time.Seconds()*float64(rate)), end)
+		end = start + len(v)
+		if end > len(voice) {
+			voice = append(voice, make([]int16, end-len(voice))...)
+		}
+		copy(voice[start:], v)
+	}
+	log.Printf("rendered %d pacenotes: %s", len(pacenotes), stage)
+	channels := 1
+	if withCapture {
+		channels = 2
+	}
+	f, err := wav.New(rate, 16, channels)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, len(voice)*2*channels))
+	for i, v := range voice {
+		binary.Write(buf, binary.LittleEndian, v)
+		if withCapture {
+			var c int16
+			if i < len(recorded) {
+				c = recorded[i]
+			}
+			binary.Write(buf, binary.LittleEndian, c)
+		}
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return wav.Marshal(f)
+}
+
+// render は /api/render/{location}/{stage}.wav?capture=1 でペースノートの読み上げを wav にする
+func render(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := func() error {
+		if !strings.HasSuffix(r.URL.Path, ".wav") {
+			return fmt.Errorf("not found: %q", r.URL.Path)
+		}
+		stage := GetFilePath(strings.TrimSuffix(r.URL.Path, ".wav") + "/")
+		if stage == "" {
+			return fmt.Errorf("stage not found: %q", r.URL.Path)
+		}
+		b, err := RenderStage(r.Context(), stage, r.URL.Query().Get("capture") != "")
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "audio/wav")
+		_, err = w.Write(b)
+		return err
+	}(); err != nil {
+		log.Println(err)
+		w.Header().Set("Content-Type", "application/json")
+		b, _ := json.Marshal(Result{false, err.Error()})
+		http.Error(w, string(b), http.StatusNotFound)
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/nobonobo/wrc-pacenote-mod/capture"
+)
+
+func TestLoadCapture(t *testing.T) {
+	tests := []struct {
+		name   string
+		format capture.WavFormat
+		pcm    []any // チャンネル順に並べたサンプル
+		want   []int16
+	}{
+		{
+			"float",
+			capture.WavFormat{Channels: 2, SamplesPerSec: 8000, BitsPerSample: 32, Float: true},
+			[]any{float32(0.5), float32(0.5), float32(-0.5), float32(-0.25)},
+			[]int16{math.MaxInt16 / 2, -math.MaxInt16 * 3 / 8},
+		},
+		{
+			// 小さい正の整数は float として読むと非正規化数になる
+			"quiet 32bit integer",
+			capture.WavFormat{Channels: 1, SamplesPerSec: 8000, BitsPerSample: 32},
+			[]any{int32(1 << 24), int32(1 << 28)},
+			[]int16{math.MaxInt16 / 128, math.MaxInt16 / 8},
+		},
+		{
+			"16bit integer",
+			capture.WavFormat{Channels: 1, SamplesPerSec: 8000, BitsPerSample: 16},
+			[]any{int16(math.MinInt16 / 2), int16(0)},
+			[]int16{-math.MaxInt16 / 2, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pcm := &bytes.Buffer{}
+			for _, v := range tt.pcm {
+				binary.Write(pcm, binary.LittleEndian, v)
+			}
+			b, err := tt.format.Marshal(pcm.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			fpath := filepath.Join(t.TempDir(), "capture.wav")
+			if err := os.WriteFile(fpath, b, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := loadCapture(fpath, 8000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("samples = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nobonobo/wrc-pacenote-mod/easportswrc"
+)
+
+// RichSample は telemetry.csv.gz の1行分(パケット全体)
+type RichSample struct {
+	Audio  time.Duration // 記録時の音声キャプチャ時刻
+	Packet easportswrc.PacketEASportsWRC
+}
+
+// RichHeader は telemetry.csv.gz のヘッダ
+func RichHeader() []string {
+	return append([]string{"Audio"}, easportswrc.Fields()...)
+}
+
+// LoadRichTelemetry は telemetry.csv.gz を読み込む
+func LoadRichTelemetry(fpath string) ([]RichSample, error) {
+	fp, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	gz, err := gzip.NewReader(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	r := csv.NewReader(gz)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+	if len(header) == 0 || header[0] != "Audio" {
+		return nil, fmt.Errorf("invalid rich telemetry header: %q", fpath)
+	}
+	samples := []RichSample{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		ts, err := strconv.ParseInt(record[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		s := RichSample{Audio: time.Duration(ts)}
+		if err := s.Packet.SetValues(header[1:], record[1:]); err != nil {
+			continue
+		}
+		samples = append(samples, s)
+	}
+	return samples, nil
+}
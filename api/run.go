@@ -0,0 +1,279 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nobonobo/wrc-pacenote-mod/config"
+)
+
+// RunPoint は走行記録の1点
+type RunPoint struct {
+	Distance float64 `json:"distance"` // ステージ距離(m)
+	Time     float64 `json:"time"`     // ステージタイム(秒)
+	Speed    float64 `json:"speed"`    // m/s
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	Z        float64 `json:"z"`
+}
+
+// Run はテイク1回分の走行記録。Distance の昇順に並ぶ。
+type Run struct {
+	Take     int        `json:"take"`
+	Finished bool       `json:"finished"`
+	Points   []RunPoint `json:"-"`
+}
+
+// TakeSuffix はテイク番号に対応する記録ファイルのサフィックス(0 は無印)
+func TakeSuffix(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprintf(".%d", n)
+}
+
+// ListTakes はステージフォルダにある記録したテイク番号を返す
+func ListTakes(dir string) []int {
+	return listTakes(dir, "telemetry.log")
+}
+
+// listTakes は names のいずれかがあるテイク番号を返す
+func listTakes(dir string, names ...string) []int {
+	res := []int{}
+	for _, name := range names {
+		matches, _ := filepath.Glob(filepath.Join(dir, name+"*"))
+		for _, m := range matches {
+			suffix := strings.TrimPrefix(filepath.Base(m), name)
+			if suffix == "" {
+				res = append(res, 0)
+				continue
+			}
+			if n, err := strconv.Atoi(strings.TrimPrefix(suffix, ".")); err == nil && n > 0 {
+				res = append(res, n)
+			}
+		}
+	}
+	sort.Ints(res)
+	return slices.Compact(res)
+}
+
+// LoadRun はテイクの走行記録を読み込む。telemetry.csv.gz があればステージ距離と速度をそのまま使い、
+// なければ telemetry.log の座標から距離と速度を求める。
+func LoadRun(dir string, n int) (*Run, error) {
+	suffix := TakeSuffix(n)
+	take, err := LoadTake(filepath.Join(dir, "take.json"+suffix))
+	if err != nil {
+		return nil, err
+	}
+	run := &Run{Take: n, Finished: take.Finish != nil}
+	if rich, err := LoadRichTelemetry(filepath.Join(dir, "telemetry.csv.gz"+suffix)); err == nil {
+		for _, s := range rich {
+			p := s.Packet
+			run.Points = append(run.Points, RunPoint{
+				Distance: p.StageCurrentDistance,
+				Time:     float64(p.StageCurrentTime),
+				Speed:    float64(p.VehicleSpeed),
+				X:        float64(p.VehiclePositionX),
+				Y:        float64(p.VehiclePositionY),
+				Z:        float64(p.VehiclePositionZ),
+			})
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	} else {
+		samples, err := LoadTelemetry(filepath.Join(dir, "telemetry.log"+suffix))
+		if err != nil {
+			return nil, err
+		}
+		Align(samples, take)
+		distances := pathDistances(samples)
+		for i, s := range samples {
+			t := s.Time.Seconds()
+			if s.HasPacket {
+				t = s.StageTime
+			}
+			pt := RunPoint{Distance: distances[i], Time: t, X: s.X, Y: s.Y, Z: s.Z}
+			if i > 0 {
+				prev := run.Points[i-1]
+				if dt := t - prev.Time; dt > 0 {
+					pt.Speed = (pt.Distance - prev.Distance) / dt
+				} else {
+					pt.Speed = prev.Speed
+				}
+			}
+			run.Points = append(run.Points, pt)
+		}
+	}
+	// 距離が戻る点(リスタート直後のノイズなど)は除く
+	points := run.Points[:0]
+	for _, p := range run.Points {
+		if len(points) > 0 && p.Distance < points[len(points)-1].Distance {
+			continue
+		}
+		points = append(points, p)
+	}
+	run.Points = points
+	if len(run.Points) == 0 {
+		return nil, fmt.Errorf("telemetry is empty: %q take %d", dir, n)
+	}
+	return run, nil
+}
+
+// Length は記録した最長のステージ距離
+func (r *Run) Length() float64 {
+	return r.Points[len(r.Points)-1].Distance
+}
+
+// Duration は記録の最終ステージタイム
+func (r *Run) Duration() float64 {
+	return r.Points[len(r.Points)-1].Time
+}
+
+// At はステージ距離 d の地点の状態を線形補間で求める
+func (r *Run) At(d float64) RunPoint {
+	i := sort.Search(len(r.Points), func(i int) bool { return r.Points[i].Distance >= d })
+	if i == 0 {
+		return r.Points[0]
+	}
+	if i >= len(r.Points) {
+		return r.Points[len(r.Points)-1]
+	}
+	a, b := r.Points[i-1], r.Points[i]
+	span := b.Distance - a.Distance
+	if span <= 0 {
+		return b
+	}
+	k := (d - a.Distance) / span
+	lerp := func(x, y float64) float64 { return x + (y-x)*k }
+	return RunPoint{
+		Distance: d,
+		Time:     lerp(a.Time, b.Time),
+		Speed:    lerp(a.Speed, b.Speed),
+		X:        lerp(a.X, b.X),
+		Y:        lerp(a.Y, b.Y),
+		Z:        lerp(a.Z, b.Z),
+	}
+}
+
+// LoadBestRun は完走したテイクの中で最速の記録を返す。無ければ nil。
+func LoadBestRun(dir string) *Run {
+	var best *Run
+	for _, n := range ListTakes(dir) {
+		run, err := LoadRun(dir, n)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if !run.Finished {
+			continue
+		}
+		if best == nil || run.Duration() < best.Duration() {
+			best = run
+		}
+	}
+	return best
+}
+
+// CompareStep は2つの記録の比較結果の1点。差は b - a。
+type CompareStep struct {
+	Distance  float64 `json:"distance"`
+	Delta     float64 `json:"delta"`     // タイム差(秒)
+	SpeedDiff float64 `json:"speedDiff"` // 速度差(m/s)
+	Offset    float64 `json:"offset"`    // 位置のずれ(m)
+}
+
+type Comparison struct {
+	A     *Run          `json:"a"`
+	B     *Run          `json:"b"`
+	Steps []CompareStep `json:"steps"`
+}
+
+// CompareRuns は2つの記録をステージ距離 step m 毎に比較する
+func CompareRuns(a, b *Run, step float64) *Comparison {
+	res := &Comparison{A: a, B: b, Steps: []CompareStep{}}
+	end := math.Min(a.Length(), b.Length())
+	for d := 0.0; d <= end; d += step {
+		pa, pb := a.At(d), b.At(d)
+		res.Steps = append(res.Steps, CompareStep{
+			Distance:  d,
+			Delta:     pb.Time - pa.Time,
+			SpeedDiff: pb.Speed - pa.Speed,
+			Offset:    math.Sqrt((pb.X-pa.X)*(pb.X-pa.X) + (pb.Y-pa.Y)*(pb.Y-pa.Y) + (pb.Z-pa.Z)*(pb.Z-pa.Z)),
+		})
+	}
+	return res
+}
+
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// compare は /api/compare/{location}/{stage}/?a=0&b=1&step=10 でテイク同士を比較する
+func compare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := func() error {
+		stage := GetFilePath(r.URL.Path)
+		if stage == "" {
+			return fmt.Errorf("stage not found: %q", r.URL.Path)
+		}
+		dir := filepath.Join(config.Config.LogDir, stage)
+		na, err := queryInt(r, "a", 0)
+		if err != nil {
+			return err
+		}
+		nb, err := queryInt(r, "b", 1)
+		if err != nil {
+			return err
+		}
+		step, err := queryInt(r, "step", 10)
+		if err != nil {
+			return err
+		}
+		if step <= 0 {
+			return fmt.Errorf("invalid step: %d", step)
+		}
+		a, err := LoadRun(dir, na)
+		if err != nil {
+			return err
+		}
+		b, err := LoadRun(dir, nb)
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(CompareRuns(a, b, float64(step)))
+	}(); err != nil {
+		log.Println(err)
+		b, _ := json.Marshal(Result{false, err.Error()})
+		http.Error(w, string(b), http.StatusNotFound)
+	}
+}
+
+// stages は /api/stages/{location}/{stage}/compare?a=0&b=1&step=10 を /api/compare と同じく扱う
+func stages(w http.ResponseWriter, r *http.Request) {
+	p, ok := strings.CutSuffix(strings.TrimSuffix(r.URL.Path, "/"), "/compare")
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		b, _ := json.Marshal(Result{false, fmt.Sprintf("not found: %q", r.URL.Path)})
+		http.Error(w, string(b), http.StatusNotFound)
+		return
+	}
+	r.URL.Path = p + "/"
+	compare(w, r)
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/nobonobo/wrc-pacenote-mod/config"
+)
+
+// Finish は記録時の完走判定
+type Finish struct {
+	Reason      string  `json:"reason"`
+	Distance    float64 `json:"distance"`
+	StageLength float64 `json:"stageLength"`
+	StageTime   float64 `json:"stageTime"`
+}
+
+// Take は記録テイク毎のメタデータ(take.json)
+type Take struct {
+	Offset float64 `json:"offset"` // 音声に対してテレメトリをずらす秒数
+	Finish *Finish `json:"finish,omitempty"`
+}
+
+// TakeFiles はテイク毎に連番サフィックス(TakeSuffix)を付けて保存するファイル。
+// pacenote.log と regions.log はパックを別テイクとして読み込んだ場合にもできる。
+var TakeFiles = []string{"telemetry.log", "telemetry.csv.gz", "capture.wav", "take.json", "playback.log", "pacenote.log", "regions.log"}
+
+// TakeEntry は GET /api/takes の応答の1テイク分
+type TakeEntry struct {
+	Take  int      `json:"take"`
+	Files []string `json:"files"` // テイクにあるファイル(サフィックスなしの名前)
+}
+
+// ListTakeEntries はステージフォルダにある全テイクとそのファイルを返す
+func ListTakeEntries(dir string) []TakeEntry {
+	res := []TakeEntry{}
+	for _, n := range listTakes(dir, TakeFiles...) {
+		entry := TakeEntry{Take: n, Files: []string{}}
+		for _, name := range TakeFiles {
+			if _, err := os.Stat(filepath.Join(dir, name+TakeSuffix(n))); err == nil {
+				entry.Files = append(entry.Files, name)
+			}
+		}
+		res = append(res, entry)
+	}
+	return res
+}
+
+// takeInfo は GET /api/take の応答。Clock は保存せず読み込み時に毎回求める。
+type takeInfo struct {
+	*Take
+	Clock *Clock `json:"clock,omitempty"`
+}
+
+func LoadTake(fpath string) (*Take, error) {
+	take := &Take{}
+	b, err := os.ReadFile(fpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return take, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, take); err != nil {
+		return nil, err
+	}
+	return take, nil
+}
+
+func SaveTake(fpath string, take *Take) error {
+	b, err := json.MarshalIndent(take, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fpath, b, 0o644)
+}
+
+// loadAlignedTelemetry はステージの telemetry.log を take.json の補正込みで読み込む
+func loadAlignedTelemetry(stage string) ([]Sample, *Take, error) {
+	return loadTakeTelemetry(stage, 0)
+}
+
+// loadTakeTelemetry はテイク n の telemetry.log を take.json の補正込みで読み込む
+func loadTakeTelemetry(stage string, n int) ([]Sample, *Take, error) {
+	dir := filepath.Join(config.Config.LogDir, stage)
+	take, err := LoadTake(filepath.Join(dir, "take.json"+TakeSuffix(n)))
+	if err != nil {
+		return nil, nil, err
+	}
+	samples, err := LoadTelemetry(filepath.Join(dir, "telemetry.log"+TakeSuffix(n)))
+	if err != nil {
+		return nil, nil, err
+	}
+	Align(samples, take)
+	return samples, take, nil
+}
+
+// getTake は /api/take/{location}/{stage}/?take=1 でテイクの take.json を返す。take を省略すると無印。
+// パックから読み込んだテイクのように telemetry.log が無ければ Clock は付けない。
+func getTake(w http.ResponseWriter, r *http.Request) error {
+	stage := GetFilePath(r.URL.Path)
+	if stage == "" {
+		return fmt.Errorf("stage not found: %q", r.URL.Path)
+	}
+	n, err := queryInt(r, "take", 0)
+	if err != nil {
+		return err
+	}
+	samples, take, err := loadTakeTelemetry(stage, n)
+	if os.IsNotExist(err) {
+		samples = nil
+		take, err = LoadTake(filepath.Join(config.Config.LogDir, stage, "take.json"+TakeSuffix(n)))
+	}
+	if err != nil {
+		return err
+	}
+	info := takeInfo{Take: take}
+	if clock, ok := FitClock(samples); ok {
+		info.Clock = &clock
+	}
+	return json.NewEncoder(w).Encode(info)
+}
+
+func postTake(w http.ResponseWriter, r *http.Request) error {
+	stage := GetFilePath(r.URL.Path)
+	if stage == "" {
+		return fmt.Errorf("stage not found: %q", r.URL.Path)
+	}
+	n, err := queryInt(r, "take", 0)
+	if err != nil {
+		return err
+	}
+	var req Take
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return err
+	}
+	fpath := filepath.Join(config.Config.LogDir, stage, "take.json"+TakeSuffix(n))
+	take, err := LoadTake(fpath)
+	if err != nil {
+		return err
+	}
+	take.Offset = req.Offset
+	log.Printf("take save to: %q offset=%f", fpath, take.Offset)
+	if err := SaveTake(fpath, take); err != nil {
+		return fmt.Errorf("take.json save failed: %w", err)
+	}
+	return json.NewEncoder(w).Encode(Result{true, ""})
+}
+
+func takes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	default:
+		errMsg := http.StatusText(http.StatusMethodNotAllowed)
+		log.Println(errMsg)
+		b, _ := json.Marshal(Result{false, errMsg})
+		http.Error(w, string(b), http.StatusMethodNotAllowed)
+	case "GET":
+		if err := getTake(w, r); err != nil {
+			log.Println(err)
+			b, _ := json.Marshal(Result{false, err.Error()})
+			http.Error(w, string(b), http.StatusBadRequest)
+		}
+	case "POST":
+		if err := postTake(w, r); err != nil {
+			log.Println(err)
+			b, _ := json.Marshal(Result{false, err.Error()})
+			http.Error(w, string(b), http.StatusBadRequest)
+		}
+	}
+}
+
+// takeList は /api/takes/{location}/{stage}/ でステージの全テイクとそのファイルを返す
+func takeList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	stage := GetFilePath(r.URL.Path)
+	if stage == "" {
+		err := fmt.Errorf("stage not found: %q", r.URL.Path)
+		log.Println(err)
+		b, _ := json.Marshal(Result{false, err.Error()})
+		http.Error(w, string(b), http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(ListTakeEntries(filepath.Join(config.Config.LogDir, stage)))
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nobonobo/wrc-pacenote-mod/config"
+)
+
+func TestTakeHandlers(t *testing.T) {
+	saved := config.Config
+	defer func() { config.Config = saved }()
+	config.Config.LogDir = t.TempDir()
+	dir := filepath.Join(config.Config.LogDir, GetFilePath("/1/1/"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// テイク 0 は記録、テイク 1 はパックから読み込んだペースノートだけ
+	for name, content := range map[string]string{
+		"telemetry.log":  "1,0,0,0,0\n",
+		"take.json":      `{"offset": 0.5}`,
+		"regions.log":    "0.100000,0.200000,mine\n",
+		"pacenote.log.1": "1.000000,2.000000,3.000000,theirs\n",
+		"regions.log.1":  "0.500000,1.000000,theirs\n",
+		"regions.log.x":  "ignored\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(handler http.HandlerFunc, target string, v any) int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w.Code
+	}
+
+	var entries []TakeEntry
+	get(takeList, "/1/1/", &entries)
+	want := []TakeEntry{
+		{Take: 0, Files: []string{"telemetry.log", "take.json", "regions.log"}},
+		{Take: 1, Files: []string{"pacenote.log", "regions.log"}},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("takes = %+v, want %+v", entries, want)
+	}
+
+	for _, tt := range []struct {
+		target string
+		want   string
+	}{
+		{"/1/1/", "mine"},
+		{"/1/1/?take=1", "theirs"},
+	} {
+		var got Regions
+		get(regions, tt.target, &got)
+		if len(got) != 1 || got[0].Content != tt.want {
+			t.Errorf("regions %s = %+v, want %q", tt.target, got, tt.want)
+		}
+	}
+
+	var info takeInfo
+	if code := get(takes, "/1/1/?take=1", &info); code != http.StatusOK || info.Take == nil || info.Clock != nil {
+		t.Errorf("take 1 = %d %+v, want take.json without a clock", code, info)
+	}
+	if code := get(takes, "/1/1/?take=x", &info); code != http.StatusBadRequest {
+		t.Errorf("invalid take = %d, want %d", code, http.StatusBadRequest)
+	}
+}
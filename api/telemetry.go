@@ -0,0 +1,165 @@
+package api
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sample は telemetry.log の1行分
+type Sample struct {
+	UID       uint64
+	Audio     time.Duration // 記録時の音声キャプチャ時刻
+	Time      time.Duration // 補正後の音声時刻
+	X, Y, Z   float64
+	GameTime  float64 // GameTotalTime
+	StageTime float64 // StageCurrentTime
+	HasPacket bool    // パケット時刻を含むかどうか
+}
+
+func parseSample(text string) (Sample, bool) {
+	fields := strings.Split(text, ",")
+	if len(fields) < 5 {
+		return Sample{}, false
+	}
+	var s Sample
+	uid, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return Sample{}, false
+	}
+	s.UID = uid
+	ts, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return Sample{}, false
+	}
+	s.Audio = time.Duration(ts)
+	s.Time = s.Audio
+	pos := [3]float64{}
+	for i := range pos {
+		p, err := strconv.ParseFloat(fields[i+2], 64)
+		if err != nil {
+			return Sample{}, false
+		}
+		pos[i] = p
+	}
+	s.X, s.Y, s.Z = pos[0], pos[1], pos[2]
+	if len(fields) >= 7 {
+		gt, err1 := strconv.ParseFloat(fields[5], 64)
+		st, err2 := strconv.ParseFloat(fields[6], 64)
+		if err1 == nil && err2 == nil {
+			s.GameTime, s.StageTime = gt, st
+			s.HasPacket = true
+		}
+	}
+	return s, true
+}
+
+// LoadTelemetry は telemetry.log を読み込む
+func LoadTelemetry(fpath string) ([]Sample, error) {
+	fp, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	samples := []Sample{}
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		s, ok := parseSample(scanner.Text())
+		if !ok {
+			continue
+		}
+		samples = append(samples, s)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// Clock はパケット時刻(秒)から音声時刻(秒)への一次写像
+type Clock struct {
+	Scale  float64 `json:"scale"`
+	Offset float64 `json:"offset"`
+}
+
+func (c Clock) Audio(gameTime float64) time.Duration {
+	return time.Duration((c.Scale*gameTime + c.Offset) * float64(time.Second))
+}
+
+func fitLine(xs, ys []float64) (Clock, bool) {
+	n := float64(len(xs))
+	if n < 2 {
+		return Clock{}, false
+	}
+	var sx, sy, sxx, sxy float64
+	for i := range xs {
+		sx += xs[i]
+		sy += ys[i]
+		sxx += xs[i] * xs[i]
+		sxy += xs[i] * ys[i]
+	}
+	den := n*sxx - sx*sx
+	if den == 0 {
+		return Clock{}, false
+	}
+	scale := (n*sxy - sx*sy) / den
+	return Clock{Scale: scale, Offset: (sy - scale*sx) / n}, true
+}
+
+// FitClock はパケット時刻と音声時刻の対応を最小二乗法で求める。
+// 音声時刻はバッファ周期で量子化され、遅延したチャンクで外れ値になるため
+// 一度当てはめた後に残差の大きいものを除いて再計算する。
+func FitClock(samples []Sample) (Clock, bool) {
+	xs, ys := []float64{}, []float64{}
+	for _, s := range samples {
+		if !s.HasPacket {
+			continue
+		}
+		xs = append(xs, s.GameTime)
+		ys = append(ys, s.Audio.Seconds())
+	}
+	c, ok := fitLine(xs, ys)
+	if !ok {
+		return Clock{}, false
+	}
+	residual := func(i int) float64 {
+		return ys[i] - (c.Scale*xs[i] + c.Offset)
+	}
+	var sum float64
+	for i := range xs {
+		sum += residual(i) * residual(i)
+	}
+	sigma := math.Sqrt(sum / float64(len(xs)))
+	fx, fy := []float64{}, []float64{}
+	for i := range xs {
+		if math.Abs(residual(i)) <= 3*sigma {
+			fx = append(fx, xs[i])
+			fy = append(fy, ys[i])
+		}
+	}
+	if refit, ok := fitLine(fx, fy); ok {
+		c = refit
+	}
+	// ゲームと音声はどちらも実時間で進むので大きく外れた傾きは採用しない
+	if c.Scale < 0.9 || c.Scale > 1.1 {
+		return Clock{}, false
+	}
+	return c, true
+}
+
+// Align は各サンプルの Time をクロック補正とテイクのオフセットで更新する
+func Align(samples []Sample, take *Take) {
+	clock, ok := FitClock(samples)
+	offset := time.Duration(take.Offset * float64(time.Second))
+	for i := range samples {
+		s := &samples[i]
+		if ok && s.HasPacket {
+			s.Time = clock.Audio(s.GameTime) + offset
+		} else {
+			s.Time = s.Audio + offset
+		}
+	}
+}
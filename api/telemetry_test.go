@@ -0,0 +1,77 @@
+package api
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// clockSamples は gameTime から audio = scale*gameTime + offset となるサンプルを作る。
+// 音声時刻は 10ms 周期で量子化し、outliers 番目毎に 200ms 遅れたチャンクを混ぜる。
+func clockSamples(n int, scale, offset float64, outliers int) []Sample {
+	res := []Sample{}
+	for i := range n {
+		game := 100 + float64(i)/60
+		audio := scale*game + offset
+		audio = math.Floor(audio*100) / 100
+		if outliers > 0 && i%outliers == 0 {
+			audio += 0.2
+		}
+		res = append(res, Sample{
+			Audio:     time.Duration(audio * float64(time.Second)),
+			GameTime:  game,
+			HasPacket: true,
+		})
+	}
+	return res
+}
+
+func TestFitClock(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []Sample
+		ok      bool
+		scale   float64
+		offset  float64
+	}{
+		{"exact", clockSamples(600, 1, -95, 0), true, 1, -95},
+		{"drift", clockSamples(600, 1.001, -100, 0), true, 1.001, -100},
+		{"late chunks", clockSamples(600, 1, -95, 50), true, 1, -95},
+		{"too few samples", clockSamples(1, 1, -95, 0), false, 0, 0},
+		{"no packet time", []Sample{{Audio: time.Second}, {Audio: 2 * time.Second}}, false, 0, 0},
+		{"implausible scale", clockSamples(600, 2, 0, 0), false, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, ok := FitClock(tt.samples)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if math.Abs(c.Scale-tt.scale) > 1e-3 {
+				t.Errorf("scale = %f, want %f", c.Scale, tt.scale)
+			}
+			// 量子化による誤差(最大 10ms)を許容する
+			if math.Abs(c.Offset-tt.offset) > 0.01 {
+				t.Errorf("offset = %f, want %f", c.Offset, tt.offset)
+			}
+		})
+	}
+}
+
+func TestAlign(t *testing.T) {
+	samples := clockSamples(600, 1, -95, 50)
+	samples = append(samples, Sample{Audio: 10 * time.Second})
+	Align(samples, &Take{Offset: 0.5})
+	for i, s := range samples[:600] {
+		want := time.Duration((s.GameTime - 95 + 0.5) * float64(time.Second))
+		if d := s.Time - want; d < -10*time.Millisecond || d > 10*time.Millisecond {
+			t.Fatalf("samples[%d].Time = %v, want %v", i, s.Time, want)
+		}
+	}
+	if got := samples[600].Time; got != 10500*time.Millisecond {
+		t.Errorf("sample without packet time = %v, want %v", got, 10500*time.Millisecond)
+	}
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nobonobo/wrc-pacenote-mod/config"
+)
+
+// Split はチェックポイント通過時のステージタイム
+type Split struct {
+	Distance float64 `json:"distance"`
+	Time     float64 `json:"time"`
+}
+
+// StageResult は完走1回分のタイム
+type StageResult struct {
+	Date   time.Time `json:"date"`
+	Mode   Mode      `json:"mode"`
+	Reason string    `json:"reason"` // 完走判定の方法
+	Time   float64   `json:"time"`
+	Splits []Split   `json:"splits"`
+}
+
+// StageTimes はステージ毎のタイム履歴(times.json)
+type StageTimes struct {
+	Best    *StageResult  `json:"best,omitempty"`
+	History []StageResult `json:"history"`
+}
+
+var timesMu sync.Mutex
+
+func LoadStageTimes(dir string) (*StageTimes, error) {
+	times := &StageTimes{History: []StageResult{}}
+	b, err := os.ReadFile(filepath.Join(dir, "times.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return times, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, times); err != nil {
+		return nil, err
+	}
+	return times, nil
+}
+
+// AddStageResult は完走タイムを履歴に加え、それまでのベストを返す
+func AddStageResult(dir string, result StageResult) (*StageResult, error) {
+	timesMu.Lock()
+	defer timesMu.Unlock()
+	times, err := LoadStageTimes(dir)
+	if err != nil {
+		return nil, err
+	}
+	prev := times.Best
+	times.History = append(times.History, result)
+	if prev == nil || result.Time < prev.Time {
+		times.Best = &result
+	}
+	b, err := json.MarshalIndent(times, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "times.json"), b, 0o644); err != nil {
+		return nil, err
+	}
+	return prev, nil
+}
+
+func stageTimes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := func() error {
+		stage := GetFilePath(r.URL.Path)
+		if stage == "" {
+			return fmt.Errorf("stage not found: %q", r.URL.Path)
+		}
+		times, err := LoadStageTimes(filepath.Join(config.Config.LogDir, stage))
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(times)
+	}(); err != nil {
+		log.Println(err)
+		b, _ := json.Marshal(Result{false, err.Error()})
+		http.Error(w, string(b), http.StatusNotFound)
+	}
+}
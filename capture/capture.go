@@ -1,3 +1,5 @@
+//go:build windows
+
 package capture
 
 import (
@@ -12,20 +14,20 @@ import (
 	"github.com/moutend/go-wca/pkg/wca"
 )
 
-type WavFormat struct {
-	Channels      uint16
-	SamplesPerSec uint32
-	BitsPerSample uint16
-}
+var oleInitialized = false
 
-type Chunk struct {
-	Format          *WavFormat
-	CurrentDuration time.Duration
-	Buffer          []byte
+// mixFloat はミックス形式のサンプルが float かどうかを返す
+func mixFloat(wfx *wca.WAVEFORMATEX) bool {
+	switch wfx.WFormatTag {
+	case 3: // WAVE_FORMAT_IEEE_FLOAT
+		return true
+	case 0xfffe: // WAVE_FORMAT_EXTENSIBLE
+		// SubFormat は WAVEFORMATEX(18byte)、Samples(2byte)、dwChannelMask(4byte) の後ろ
+		return *(*[16]byte)(unsafe.Add(unsafe.Pointer(wfx), 24)) == subtypeIEEEFloat
+	}
+	return false
 }
 
-var oleInitialized = false
-
 func Capture(ctx context.Context, output func(Chunk)) error {
 	runtime.LockOSThread()
 	if !oleInitialized {
@@ -84,6 +86,7 @@ func Capture(ctx context.Context, output func(Chunk)) error {
 	}
 	defer ole.CoTaskMemFree(uintptr(unsafe.Pointer(wfx)))
 
+	float := mixFloat(wfx)
 	wfx.WFormatTag = 1
 	wfx.NBlockAlign = (wfx.WBitsPerSample / 8) * wfx.NChannels
 	wfx.NAvgBytesPerSec = wfx.NSamplesPerSec * uint32(wfx.NBlockAlign)
@@ -93,10 +96,15 @@ func Capture(ctx context.Context, output func(Chunk)) error {
 		SamplesPerSec: wfx.NSamplesPerSec,
 		Channels:      wfx.NChannels,
 		BitsPerSample: wfx.WBitsPerSample,
+		Float:         float,
 	}
 
 	log.Println("--------")
-	log.Printf("Format: PCM %d bit signed integer\n", wfx.WBitsPerSample)
+	if float {
+		log.Printf("Format: %d bit float\n", wfx.WBitsPerSample)
+	} else {
+		log.Printf("Format: PCM %d bit signed integer\n", wfx.WBitsPerSample)
+	}
 	log.Printf("Rate: %d Hz\n", wfx.NSamplesPerSec)
 	log.Printf("Channels: %d\n", wfx.NChannels)
 	log.Println("--------")
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package capture
+
+import (
+	"context"
+	"errors"
+)
+
+// Capture はループバック録音に WASAPI を使うため Windows 以外では利用できない
+func Capture(ctx context.Context, output func(Chunk)) error {
+	return errors.New("audio capture is only supported on windows")
+}
@@ -0,0 +1,16 @@
+package capture
+
+import "time"
+
+type WavFormat struct {
+	Channels      uint16
+	SamplesPerSec uint32
+	BitsPerSample uint16
+	Float         bool // サンプルが32bit float
+}
+
+type Chunk struct {
+	Format          *WavFormat
+	CurrentDuration time.Duration
+	Buffer          []byte
+}
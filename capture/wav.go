@@ -0,0 +1,48 @@
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/moutend/go-wav"
+)
+
+// subtypeIEEEFloat は WAVE_FORMAT_EXTENSIBLE の SubFormat(KSDATAFORMAT_SUBTYPE_IEEE_FLOAT)
+var subtypeIEEEFloat = [16]byte{0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xaa, 0x00, 0x38, 0x9b, 0x71}
+
+// subFormatOffset は go-wav が書き出す WAVE_FORMAT_EXTENSIBLE ヘッダでの SubFormat の位置
+const subFormatOffset = 44
+
+// Marshal は録音したサンプルを wav にする。
+// go-wav は WAVE_FORMAT_IEEE_FLOAT を読めないので float は EXTENSIBLE の SubFormat で表す。
+func (f *WavFormat) Marshal(pcm []byte) ([]byte, error) {
+	w, err := wav.New(int(f.SamplesPerSec), int(f.BitsPerSample), int(f.Channels))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(pcm); err != nil {
+		return nil, err
+	}
+	b, err := wav.Marshal(w)
+	if err != nil {
+		return nil, err
+	}
+	if f.Float {
+		if w.FormatTag() != wav.WAVE_FORMAT_EXTENSIBLE {
+			return nil, fmt.Errorf("unsupported float format: %d bit", f.BitsPerSample)
+		}
+		copy(b[subFormatOffset:], subtypeIEEEFloat[:])
+	}
+	return b, nil
+}
+
+// IsFloat は Marshal で書き出した wav のサンプルが float かどうかをヘッダから判定する
+func IsFloat(b []byte) bool {
+	if len(b) < subFormatOffset+len(subtypeIEEEFloat) {
+		return false
+	}
+	if binary.LittleEndian.Uint16(b[20:]) != wav.WAVE_FORMAT_EXTENSIBLE {
+		return false
+	}
+	return [16]byte(b[subFormatOffset:]) == subtypeIEEEFloat
+}
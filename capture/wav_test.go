@@ -0,0 +1,45 @@
+package capture
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/moutend/go-wav"
+)
+
+func TestMarshal(t *testing.T) {
+	pcm := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	tests := []struct {
+		name   string
+		format WavFormat
+		float  bool
+		err    bool
+	}{
+		{"float", WavFormat{Channels: 2, SamplesPerSec: 48000, BitsPerSample: 32, Float: true}, true, false},
+		{"32bit integer", WavFormat{Channels: 2, SamplesPerSec: 48000, BitsPerSample: 32}, false, false},
+		{"16bit integer", WavFormat{Channels: 2, SamplesPerSec: 48000, BitsPerSample: 16}, false, false},
+		{"16bit float", WavFormat{Channels: 2, SamplesPerSec: 48000, BitsPerSample: 16, Float: true}, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.format.Marshal(pcm)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if got := IsFloat(b); got != tt.float {
+				t.Errorf("IsFloat = %v, want %v", got, tt.float)
+			}
+			// go-wav で読み戻せること
+			f := &wav.File{}
+			if err := wav.Unmarshal(b, f); err != nil {
+				t.Fatal(err)
+			}
+			if f.BitsPerSample() != int(tt.format.BitsPerSample) || !bytes.Equal(f.Bytes(), pcm) {
+				t.Errorf("unmarshaled %d bit %v, want %d bit %v", f.BitsPerSample(), f.Bytes(), tt.format.BitsPerSample, pcm)
+			}
+		})
+	}
+}
@@ -2,48 +2,64 @@ package config
 
 import (
 	"flag"
-	"log"
 	"os"
 	"path/filepath"
-
-	"golang.org/x/sys/windows"
 )
 
 var Config = struct {
-	Listen      string `json:"listen"`
-	Forward     string `json:"forward"`
-	WebListen   string `json:"web-listen"`
-	LogDir      string `json:"log-dir"`
-	VoiceVoxDir string
-	Root        string
-	Documents   string
+	Listen            string  `json:"listen"`
+	Forward           string  `json:"forward"`
+	WebListen         string  `json:"web-listen"`
+	LogDir            string  `json:"log-dir"`
+	Profile           string  `json:"profile"`
+	LiveRate          float64 `json:"live-rate"`
+	AutoDistance      string  `json:"auto-distance"`      // pacenote.log を作る時の距離の自動付与(off, append, correct)
+	DistanceTolerance float64 `json:"distance-tolerance"` // 経路と食い違う距離とみなす割合(%)
+	Normalize         bool    `json:"normalize"`          // pacenote.log を作る時に略記を辞書の単語にする
+	VoiceVoxDir       string
+	Root              string
+	Documents         string
 }{
-	Listen:    "127.0.0.1:20777",
-	Forward:   "",
-	WebListen: "127.0.0.1:8080",
-	LogDir:    "",
-	Root:      ".",
+	Listen:            "127.0.0.1:20777",
+	Forward:           "",
+	WebListen:         "127.0.0.1:8080",
+	LogDir:            "",
+	LiveRate:          10,
+	AutoDistance:      "off",
+	DistanceTolerance: 25,
+	Root:              ".",
 }
 
-func init() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-
-	Config.Root = getRootDir()
-
-	doc, err := windows.KnownFolderPath(windows.FOLDERID_Documents, 0)
+// Load はプラットフォーム毎のフォルダを調べて Config の既定値を設定する
+func Load() error {
+	root, err := getRootDir()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	Config.Root = root
+	doc, err := getDocumentsDir()
+	if err != nil {
+		return err
 	}
 	Config.Documents = doc
-	WRCDocumentRoot := os.ExpandEnv(filepath.Join(Config.Documents, "My Games", "WRC"))
+	WRCDocumentRoot := os.ExpandEnv(getWRCDocumentRoot(Config.Documents))
 	Config.LogDir = filepath.Join(WRCDocumentRoot, "pacenotes")
 	Config.VoiceVoxDir = filepath.Join(WRCDocumentRoot, "voicevox_core")
-	if err := windows.SetDllDirectory(Config.VoiceVoxDir); err != nil {
-		log.Fatal(err)
+	if err := setDllDirectory(Config.VoiceVoxDir); err != nil {
+		return err
 	}
-	flag.StringVar(&Config.Listen, "listen", Config.Listen, "listen address")
-	flag.StringVar(&Config.Forward, "forward", Config.Forward, "forward address")
-	flag.StringVar(&Config.WebListen, "web-listen", Config.WebListen, "web listen address")
-	flag.StringVar(&Config.LogDir, "log-dir", Config.LogDir, "log directory")
-	flag.Parse()
+	return nil
+}
+
+// RegisterFlags は Config のフラグを登録する。Load の後に呼ぶこと。
+func RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&Config.Listen, "listen", Config.Listen, "listen address")
+	fs.StringVar(&Config.Forward, "forward", Config.Forward, "forward address")
+	fs.StringVar(&Config.WebListen, "web-listen", Config.WebListen, "web listen address")
+	fs.StringVar(&Config.LogDir, "log-dir", Config.LogDir, "log directory")
+	fs.StringVar(&Config.Profile, "profile", Config.Profile, "profile name in config.json")
+	fs.Float64Var(&Config.LiveRate, "live-rate", Config.LiveRate, "max samples per second sent to /api/live clients")
+	fs.StringVar(&Config.AutoDistance, "auto-distance", Config.AutoDistance, "distance to the next call in pacenote.log: off, append (only where missing) or correct (also fix disagreeing distances)")
+	fs.Float64Var(&Config.DistanceTolerance, "distance-tolerance", Config.DistanceTolerance, "percent a typed distance may differ from the path before it is flagged")
+	fs.BoolVar(&Config.Normalize, "normalize", Config.Normalize, "rewrite compact notation (3L > 4R+) into dictionary words in pacenote.log when regions are saved")
 }
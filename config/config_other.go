@@ -0,0 +1,29 @@
+//go:build !windows
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// getDocumentsDir は XDG_DATA_HOME(未設定なら ~/.local/share)を返す
+func getDocumentsDir() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share"), nil
+}
+
+func getWRCDocumentRoot(documents string) string {
+	return filepath.Join(documents, "wrc-pacenote-mod")
+}
+
+// setDllDirectory は Windows 以外では不要
+func setDllDirectory(dir string) error {
+	return nil
+}
@@ -0,0 +1,19 @@
+package config
+
+import (
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+func getDocumentsDir() (string, error) {
+	return windows.KnownFolderPath(windows.FOLDERID_Documents, 0)
+}
+
+func getWRCDocumentRoot(documents string) string {
+	return filepath.Join(documents, "My Games", "WRC")
+}
+
+func setDllDirectory(dir string) error {
+	return windows.SetDllDirectory(dir)
+}
@@ -2,15 +2,8 @@
 
 package config
 
-import (
-	"log"
-	"os"
-)
+import "os"
 
-func getRootDir() string {
-	here, err := os.Getwd()
-	if err != nil {
-		log.Fatal(err)
-	}
-	return here
+func getRootDir() (string, error) {
+	return os.Getwd()
 }
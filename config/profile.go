@@ -0,0 +1,149 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// Profile はフラグ名をキーにした設定値
+type Profile map[string]any
+
+// File はログフォルダの config.json の内容
+type File struct {
+	Profile  string             `json:"profile"`
+	Profiles map[string]Profile `json:"profiles"`
+}
+
+// プロファイルで指定できない設定
+var unprofiled = map[string]bool{
+	"log-dir": true,
+	"profile": true,
+}
+
+func FilePath() string {
+	return filepath.Join(Config.LogDir, "config.json")
+}
+
+func LoadFile() (*File, error) {
+	f := &File{Profiles: map[string]Profile{}}
+	b, err := os.ReadFile(FilePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return f, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, f); err != nil {
+		return nil, fmt.Errorf("%s: %w", FilePath(), err)
+	}
+	if f.Profiles == nil {
+		f.Profiles = map[string]Profile{}
+	}
+	return f, nil
+}
+
+func SaveFile(f *File) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(Config.LogDir, 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(FilePath(), b, 0o644)
+}
+
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// cmdline はコマンドラインで指定されたフラグ
+var cmdline = map[string]bool{}
+
+// Validate はプロファイルの全てのキーが既知のフラグか確かめる
+func (f *File) Validate() error {
+	for name, p := range f.Profiles {
+		for key := range p {
+			if unprofiled[key] {
+				return fmt.Errorf("profile %q: %q can not be set in a profile", name, key)
+			}
+			if flag.Lookup(key) == nil {
+				return fmt.Errorf("profile %q: unknown setting %q", name, key)
+			}
+		}
+	}
+	if _, ok := f.Profiles[f.Profile]; f.Profile != "" && !ok {
+		return fmt.Errorf("profile not found: %q", f.Profile)
+	}
+	return nil
+}
+
+// apply は選択中のプロファイルを適用する。コマンドラインのフラグが優先される。
+// 設定値はフラグの変数を直接書き換えるので、他の処理が動き出す前の Parse からだけ呼ぶ。
+func (f *File) apply() error {
+	name := f.Profile
+	if cmdline["profile"] {
+		name = Config.Profile
+	}
+	if name == "" {
+		return nil
+	}
+	p, ok := f.Profiles[name]
+	if !ok {
+		return fmt.Errorf("profile not found: %q", name)
+	}
+	Config.Profile = name
+	keys := make([]string, 0, len(p))
+	for key := range p {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if unprofiled[key] || cmdline[key] {
+			continue
+		}
+		if err := flag.Set(key, formatValue(p[key])); err != nil {
+			return fmt.Errorf("profile %q: %s: %w", name, key, err)
+		}
+	}
+	log.Printf("profile applied: %q", name)
+	return nil
+}
+
+// Settings は現在有効な全ての設定値を返す
+func Settings() map[string]string {
+	res := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		res[f.Name] = f.Value.String()
+	})
+	return res
+}
+
+// Parse はコマンドラインのフラグを解析して config.json のプロファイルを重ねる。
+// 全てのフラグ登録後に呼ぶこと。
+func Parse() error {
+	flag.Parse()
+	flag.Visit(func(f *flag.Flag) {
+		cmdline[f.Name] = true
+	})
+	f, err := LoadFile()
+	if err != nil {
+		return err
+	}
+	if err := f.Validate(); err != nil {
+		return err
+	}
+	return f.apply()
+}
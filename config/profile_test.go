@@ -0,0 +1,43 @@
+package config
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	RegisterFlags(flag.CommandLine)
+	tests := []struct {
+		name string
+		file File
+		err  bool
+	}{
+		{"valid", File{Profile: "fast", Profiles: map[string]Profile{"fast": {"live-rate": 20.0}}}, false},
+		{"no profile", File{Profiles: map[string]Profile{}}, false},
+		{"unknown key", File{Profiles: map[string]Profile{"fast": {"no-such-flag": 1.0}}}, true},
+		{"unprofiled key", File{Profiles: map[string]Profile{"fast": {"log-dir": "x"}}}, true},
+		{"missing profile", File{Profile: "slow", Profiles: map[string]Profile{"fast": {}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.file.Validate(); (err != nil) != tt.err {
+				t.Errorf("Validate() = %v, want error %v", err, tt.err)
+			}
+		})
+	}
+
+	f := &File{Profile: "fast", Profiles: map[string]Profile{
+		"fast": {"live-rate": 20.0, "auto-distance": "append"},
+	}}
+	cmdline["auto-distance"] = true
+	defer delete(cmdline, "auto-distance")
+	if err := f.apply(); err != nil {
+		t.Fatal(err)
+	}
+	if Config.Profile != "fast" || Config.LiveRate != 20 {
+		t.Errorf("profile = %q, live-rate = %v", Config.Profile, Config.LiveRate)
+	}
+	if Config.AutoDistance != "off" {
+		t.Errorf("auto-distance = %q, command line flag was overridden", Config.AutoDistance)
+	}
+}
@@ -3,15 +3,14 @@
 package config
 
 import (
-	"log"
 	"os"
 	"path/filepath"
 )
 
-func getRootDir() string {
+func getRootDir() (string, error) {
 	self, err := os.Executable()
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
-	return filepath.Dir(self)
+	return filepath.Dir(self), nil
 }
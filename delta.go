@@ -0,0 +1,155 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/nobonobo/wrc-pacenote-mod/api"
+	"github.com/nobonobo/wrc-pacenote-mod/easportswrc"
+)
+
+var (
+	deltaInterval = float64(0) // 再生中にベスト記録とのタイム差を読み上げる間隔(m)
+	deltaSplits   = false      // -splits のチェックポイントでタイム差を読み上げる
+)
+
+func init() {
+	flag.Float64Var(&deltaInterval, "delta-interval", deltaInterval, "speak time delta against the best finished take every N meters during playback (0: disabled)")
+	flag.BoolVar(&deltaSplits, "delta-splits", deltaSplits, "speak time delta against the best finished take at the -splits checkpoints during playback")
+}
+
+// deltaCaller はチェックポイント毎にベスト記録とのタイム差を読み上げる。
+// ベスト記録はパケットの受信を止めないよう別の goroutine で読み込む。
+type deltaCaller struct {
+	mu       sync.Mutex
+	dir      string // 比較するステージ(空なら読み上げない)
+	loading  int    // 最後に始めた読み込みの番号
+	best     *api.Run
+	splits   string  // -splits 形式のチェックポイント(空なら使わない)
+	interval float64 // 一定距離毎のチェックポイント(0 なら使わない)
+	points   []float64
+	next     int
+	speech   func(text string)
+}
+
+func newDeltaCaller(speechCh chan<- string) *deltaCaller {
+	return &deltaCaller{
+		speech: func(text string) {
+			speechCh <- text
+		},
+	}
+}
+
+// Stage はステージとモードが決まった時に呼ばれる。再生するモードならベスト記録を読み込む。
+func (c *deltaCaller) Stage(dir string, mode api.Mode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dir, c.best, c.points = "", nil, nil
+	if mode != api.ModePlay && mode != api.ModeRecordWhilePlaying {
+		return
+	}
+	c.splits = ""
+	if deltaSplits {
+		c.splits = splitPoints
+	}
+	c.interval = deltaInterval
+	if c.splits == "" && c.interval <= 0 {
+		return
+	}
+	c.dir = dir
+	go c.Reload(dir)
+}
+
+// Reload は比較中のステージ dir のベスト記録を読み直す。テイクを保存した後にも呼ばれる。
+func (c *deltaCaller) Reload(dir string) {
+	c.mu.Lock()
+	if dir == "" || dir != c.dir {
+		c.mu.Unlock()
+		return
+	}
+	c.loading++
+	loading := c.loading
+	c.mu.Unlock()
+	best := api.LoadBestRun(dir)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if dir != c.dir || loading != c.loading {
+		// 読み込み中にステージが変わったか、後から始めた読み込みがある
+		return
+	}
+	if best == nil {
+		log.Printf("delta: no finished take: %q", dir)
+	} else if c.best == nil || c.best.Take != best.Take {
+		log.Printf("delta: compare with take %d (%.2fs)", best.Take, best.Duration())
+	}
+	c.best = best
+}
+
+// start はステージ長からチェックポイントを決める。走行の途中なら d までのチェックポイントは飛ばす。
+func (c *deltaCaller) start(stageLength, d float64) {
+	points := []float64{}
+	if c.splits != "" {
+		splits, err := parseSplits(c.splits, stageLength)
+		if err != nil {
+			log.Println(err)
+		}
+		points = append(points, splits...)
+	}
+	if c.interval > 0 {
+		for p := c.interval; p <= c.best.Length(); p += c.interval {
+			points = append(points, p)
+		}
+	}
+	sort.Float64s(points)
+	c.points = slices.Compact(points)
+	c.next = 0
+	for c.next < len(c.points) && c.points[c.next] <= d {
+		c.next++
+	}
+}
+
+// formatDelta はタイム差を読み上げ用の文言にする
+func formatDelta(delta float64) string {
+	sign := "プラス"
+	if delta < 0 {
+		sign = "マイナス"
+	}
+	return fmt.Sprintf("%s%.1f秒", sign, math.Abs(delta))
+}
+
+// Packet はパケット毎にチェックポイントの通過を調べる。読み上げはロックを外してから行う。
+func (c *deltaCaller) Packet(pkt *easportswrc.PacketEASportsWRC) {
+	if text := c.packet(pkt); text != "" {
+		c.speech(text)
+	}
+}
+
+func (c *deltaCaller) packet(pkt *easportswrc.PacketEASportsWRC) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.best == nil {
+		return ""
+	}
+	d := pkt.StageCurrentDistance
+	if d == 0 || c.points == nil {
+		c.start(pkt.StageLength, d)
+	}
+	if d == 0 {
+		return ""
+	}
+	if c.next >= len(c.points) || d < c.points[c.next] || c.points[c.next] > c.best.Length() {
+		return ""
+	}
+	point := c.points[c.next]
+	delta := float64(pkt.StageCurrentTime) - c.best.At(point).Time
+	api.Publish("delta", api.DeltaEvent{Distance: point, Delta: delta, Take: c.best.Take})
+	for c.next < len(c.points) && c.points[c.next] <= d {
+		c.next++
+	}
+	return formatDelta(delta)
+}
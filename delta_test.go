@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/nobonobo/wrc-pacenote-mod/api"
+	"github.com/nobonobo/wrc-pacenote-mod/easportswrc"
+)
+
+func TestDeltaCaller(t *testing.T) {
+	// ベストは 10m/s で 1000m を走った記録
+	best := &api.Run{Take: 1, Finished: true}
+	for d := 0.0; d <= 1000; d += 10 {
+		best.Points = append(best.Points, api.RunPoint{Distance: d, Time: d / 10})
+	}
+	tests := []struct {
+		name     string
+		splits   string
+		interval float64
+		want     []string
+	}{
+		{"splits", "25%,50%", 0, []string{"プラス25.0秒", "プラス50.0秒"}},
+		{"interval", "", 400, []string{"プラス40.0秒", "プラス80.0秒"}},
+		{"splits and interval", "50%", 400, []string{"プラス40.0秒", "プラス50.0秒", "プラス80.0秒"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			c := &deltaCaller{best: best, splits: tt.splits, interval: tt.interval, speech: func(text string) {
+				got = append(got, text)
+			}}
+			// 半分の速さ(5m/s)で走る
+			for d := 0.0; d <= 1000; d += 5 {
+				c.Packet(&easportswrc.PacketEASportsWRC{StageCurrentDistance: d, StageLength: 1000, StageCurrentTime: float32(d / 5)})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("speech = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	// ベスト記録が無ければ何もしない
+	c := &deltaCaller{interval: 400}
+	c.Packet(&easportswrc.PacketEASportsWRC{StageCurrentDistance: 500, StageLength: 1000})
+
+	// 走行の途中で読み込めた場合は通過済みのチェックポイントを飛ばす
+	got := []string{}
+	c = &deltaCaller{best: best, interval: 400, speech: func(text string) {
+		got = append(got, text)
+	}}
+	for d := 500.0; d <= 1000; d += 5 {
+		c.Packet(&easportswrc.PacketEASportsWRC{StageCurrentDistance: d, StageLength: 1000, StageCurrentTime: float32(d / 5)})
+	}
+	if want := []string{"プラス80.0秒"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("speech = %q, want %q", got, want)
+	}
+}
+
+// saveRun は 100m を secPer100m 秒で走って完走したテイクを保存する
+func saveRun(t *testing.T, dir string, secPer100m int) {
+	t.Helper()
+	telemetry := &bytes.Buffer{}
+	for i := range 11 {
+		fmt.Fprintf(telemetry, "%d,%d,%d,0,0\n", i+1, i*secPer100m*1e9, i*100)
+	}
+	tk := &take{dir: dir, telemetry: telemetry, meta: api.Take{Finish: &api.Finish{Reason: finishDistance}}}
+	tk.save()
+}
+
+func TestDeltaCallerReload(t *testing.T) {
+	interval := deltaInterval
+	defer func() { deltaInterval = interval }()
+	deltaInterval = 400
+	dir := t.TempDir()
+	saveRun(t, dir, 10)
+	c := newDeltaCaller(nil)
+	bestTake := func() int {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.best == nil {
+			return -1
+		}
+		return c.best.Take
+	}
+	// Stage は読み込みを待たない
+	c.Stage(dir, api.ModePlay)
+	deadline := time.Now().Add(time.Second)
+	for bestTake() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := bestTake(); got != 0 {
+		t.Fatalf("best take = %d, want 0", got)
+	}
+	// 速いテイクを保存したら読み直す
+	saveRun(t, dir, 5)
+	c.Reload(dir)
+	if got := bestTake(); got != 1 {
+		t.Errorf("best take after save = %d, want 1", got)
+	}
+	// 再生しないモードでは読み込まない
+	c.Stage(dir, api.ModeRecord)
+	c.Reload(dir)
+	if got := bestTake(); got != -1 {
+		t.Errorf("best take in record mode = %d, want none", got)
+	}
+}
@@ -0,0 +1,74 @@
+package easportswrc
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+var packetType = reflect.TypeOf(PacketEASportsWRC{})
+
+// Fields はパケットの全フィールド名(CSVのヘッダ)
+func Fields() []string {
+	res := make([]string, packetType.NumField())
+	for i := range res {
+		res[i] = packetType.Field(i).Name
+	}
+	return res
+}
+
+// Values はパケットの全フィールドを Fields の順に文字列化する
+func (p *PacketEASportsWRC) Values() []string {
+	v := reflect.ValueOf(p).Elem()
+	res := make([]string, v.NumField())
+	for i := range res {
+		f := v.Field(i)
+		switch f.Kind() {
+		case reflect.Float32:
+			res[i] = strconv.FormatFloat(f.Float(), 'g', -1, 32)
+		case reflect.Float64:
+			res[i] = strconv.FormatFloat(f.Float(), 'g', -1, 64)
+		case reflect.Uint8, reflect.Uint64:
+			res[i] = strconv.FormatUint(f.Uint(), 10)
+		case reflect.Bool:
+			res[i] = strconv.FormatBool(f.Bool())
+		}
+	}
+	return res
+}
+
+// SetValues は header の列名でフィールドを対応付けて values からパケットを復元する
+func (p *PacketEASportsWRC) SetValues(header, values []string) error {
+	v := reflect.ValueOf(p).Elem()
+	for i, name := range header {
+		if i >= len(values) {
+			break
+		}
+		f := v.FieldByName(name)
+		if !f.IsValid() {
+			continue
+		}
+		s := values[i]
+		switch f.Kind() {
+		case reflect.Float32, reflect.Float64:
+			x, err := strconv.ParseFloat(s, f.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			f.SetFloat(x)
+		case reflect.Uint8, reflect.Uint64:
+			x, err := strconv.ParseUint(s, 10, f.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			f.SetUint(x)
+		case reflect.Bool:
+			x, err := strconv.ParseBool(s)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			f.SetBool(x)
+		}
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"path/filepath"
 )
 
 const PacketEASportsWRCLength = 237
@@ -748,6 +749,30 @@ var (
 	}
 )
 
+// Dir はログフォルダ内のステージのフォルダ(ロケーション/ステージ)
+func (s *Stage) Dir() string {
+	return filepath.Join(
+		fmt.Sprintf("%02d.%s", s.ID.Location, s.Location),
+		fmt.Sprintf("%02d.%s", s.ID.Stage, s.Stage),
+	)
+}
+
+// GetStageByID はロケーション番号とステージ番号(1始まり)からステージを返す
+func GetStageByID(location, stage int) *Stage {
+	if location < 1 || location > len(Locations) {
+		return nil
+	}
+	loc := Locations[location-1]
+	if stage < 1 || stage > len(loc.Stages) {
+		return nil
+	}
+	return &Stage{
+		ID:       StageID{Location: location, Stage: stage},
+		Location: loc.Name,
+		Stage:    loc.Stages[stage-1],
+	}
+}
+
 func GetStage(sd float64) *Stage {
 	s, ok := stages[float32(sd)]
 	log.Printf("GetStage: %f %v %v", sd, s, ok)
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/nobonobo/wrc-pacenote-mod/easportswrc"
+)
+
+const (
+	finishDistance   = "distance"     // 走行距離がステージ長に達した
+	finishTimeFreeze = "time-freeze"  // ゴール付近でステージタイムが止まった
+	finishStream     = "stream"       // ゴール付近でパケットが途絶えた
+	finishLegacy     = "clutch-brake" // ゴール付近でクラッチとブレーキが全開(旧判定)
+)
+
+var (
+	finishMethods = strings.Join([]string{finishDistance, finishTimeFreeze, finishStream}, ",")
+	finishMargin  = float64(100)
+)
+
+func init() {
+	flag.StringVar(&finishMethods, "finish", finishMethods,
+		fmt.Sprintf("comma separated finish detection methods (%s,%s,%s,%s)",
+			finishDistance, finishTimeFreeze, finishStream, finishLegacy))
+	flag.Float64Var(&finishMargin, "finish-margin", finishMargin, "distance(m) before the stage end regarded as near the finish")
+}
+
+// finishDetector は完走判定を行う
+type finishDetector struct {
+	methods map[string]bool
+	margin  float64
+	frozen  int
+	legacy  int
+	last    *easportswrc.PacketEASportsWRC
+}
+
+func newFinishDetector(methods string, margin float64) *finishDetector {
+	d := &finishDetector{
+		methods: map[string]bool{},
+		margin:  margin,
+	}
+	for _, m := range strings.Split(methods, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			d.methods[m] = true
+		}
+	}
+	return d
+}
+
+func (d *finishDetector) Reset() {
+	d.frozen = 0
+	d.legacy = 0
+	d.last = nil
+}
+
+func (d *finishDetector) nearEnd(pkt *easportswrc.PacketEASportsWRC) bool {
+	return pkt.StageLength > 0 && pkt.StageCurrentDistance >= pkt.StageLength-d.margin
+}
+
+// Packet はパケット毎に呼ばれ、完走と判定したらその理由を返す
+func (d *finishDetector) Packet(pkt *easportswrc.PacketEASportsWRC) (string, bool) {
+	last := d.last
+	d.last = pkt
+	if d.methods[finishDistance] && pkt.StageLength > 0 && pkt.StageCurrentDistance >= pkt.StageLength {
+		return finishDistance, true
+	}
+	if d.methods[finishTimeFreeze] && last != nil && d.nearEnd(pkt) &&
+		pkt.GameFrameCount != last.GameFrameCount &&
+		pkt.StageCurrentTime == last.StageCurrentTime {
+		d.frozen++
+		if d.frozen >= 10 {
+			return finishTimeFreeze, true
+		}
+	} else {
+		d.frozen = 0
+	}
+	if d.methods[finishLegacy] && pkt.StageCurrentDistance > pkt.StageLength-1000 &&
+		pkt.VehicleClutch == 1.0 && pkt.VehicleBrake == 1.0 {
+		d.legacy++
+		if d.legacy > 3 {
+			return finishLegacy, true
+		}
+	}
+	return "", false
+}
+
+// Timeout はパケットが途絶えた時に呼ばれ、ゴール付近なら完走とみなす
+func (d *finishDetector) Timeout() (string, bool) {
+	if d.methods[finishStream] && d.last != nil && d.nearEnd(d.last) {
+		return finishStream, true
+	}
+	return "", false
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nobonobo/wrc-pacenote-mod/easportswrc"
+)
+
+func TestFinishDetector(t *testing.T) {
+	type pkt struct {
+		frame    uint64
+		distance float64
+		time     float32
+		clutch   float32
+		brake    float32
+	}
+	const length = 1000
+	frozen := []pkt{}
+	for i := range 12 {
+		frozen = append(frozen, pkt{frame: uint64(i + 1), distance: 950, time: 60})
+	}
+	tests := []struct {
+		name    string
+		methods string
+		packets []pkt
+		timeout bool // 最後に Timeout を呼ぶ
+		want    string
+	}{
+		{"distance reached", "distance", []pkt{{1, 500, 30, 0, 0}, {2, length, 60, 0, 0}}, false, finishDistance},
+		{"distance disabled", "time-freeze", []pkt{{1, 500, 30, 0, 0}, {2, length, 60, 0, 0}}, false, ""},
+		{"time freeze near the end", "time-freeze", frozen, false, finishTimeFreeze},
+		{"time freeze needs new frames", "time-freeze", []pkt{{1, 950, 60, 0, 0}, {1, 950, 60, 0, 0}, {1, 950, 60, 0, 0}}, false, ""},
+		{"time freeze far from the end", "time-freeze", []pkt{{1, 100, 60, 0, 0}, {2, 100, 60, 0, 0}}, false, ""},
+		{"stream stops near the end", "stream", []pkt{{1, 950, 60, 0, 0}}, true, finishStream},
+		{"stream stops mid stage", "stream", []pkt{{1, 500, 60, 0, 0}}, true, ""},
+		{"clutch and brake", "clutch-brake", []pkt{{1, 950, 60, 1, 1}, {2, 951, 60, 1, 1}, {3, 952, 60, 1, 1}, {4, 953, 60, 1, 1}}, false, finishLegacy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newFinishDetector(tt.methods, 100)
+			got := ""
+			for _, p := range tt.packets {
+				if reason, ok := d.Packet(&easportswrc.PacketEASportsWRC{
+					GameFrameCount:       p.frame,
+					StageCurrentDistance: p.distance,
+					StageLength:          length,
+					StageCurrentTime:     p.time,
+					VehicleClutch:        p.clutch,
+					VehicleBrake:         p.brake,
+				}); ok {
+					got = reason
+					break
+				}
+			}
+			if got == "" && tt.timeout {
+				got, _ = d.Timeout()
+			}
+			if got != tt.want {
+				t.Errorf("reason = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,10 +3,17 @@ export async function load({ fetch, url }) {
   let u = params.get("location") + "/" + params.get("stage") + "/";
   let stage = await (await fetch("/api/stage/" + u)).json();
   let regions = await (await fetch("/api/regions/" + u)).json();
+  let take = await (await fetch("/api/take/" + u)).json();
+  let settings = await (await fetch("/api/mode/" + u)).json();
+  let dictionaries = await (await fetch("/api/dictionaries")).json();
   return {
     url: u,
     params: params,
     stage: stage,
     regions: regions,
+    take: take,
+    mode: settings.mode,
+    dictionary: settings.dictionary || "",
+    dictionaries: dictionaries,
   };
 }
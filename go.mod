@@ -7,6 +7,7 @@ require (
 	github.com/ajstarks/svgo v0.0.0-20211024235047-1546f124cd8b
 	github.com/ebitengine/oto/v3 v3.1.0
 	github.com/go-ole/go-ole v1.3.0
+	github.com/gorilla/websocket v1.5.1
 	github.com/hajimehoshi/ebiten/v2 v2.6.4
 	github.com/moutend/go-wav v0.0.0-20170820031854-56127fbbb7ba
 	github.com/moutend/go-wca v0.3.0
@@ -16,6 +17,7 @@ require (
 require (
 	github.com/ebitengine/purego v0.5.0 // indirect
 	github.com/google/uuid v1.3.1 // indirect
+	golang.org/x/net v0.17.0 // indirect
 )
 
 replace github.com/aethiopicuschan/nanoda => github.com/nobonobo/nanoda v0.0.0-20240206001753-a844a78aa463
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/nobonobo/wrc-pacenote-mod/api"
+	"github.com/nobonobo/wrc-pacenote-mod/capture"
+)
+
+var (
+	headless   = !audioSupported // headless タグでビルドした場合は常にヘッドレス
+	speechFile = ""
+)
+
+func init() {
+	flag.BoolVar(&headless, "headless", headless, "run without audio devices: speech goes to the event stream (and -speech-file) and recordings get silent audio")
+	flag.StringVar(&speechFile, "speech-file", speechFile, "append spoken text to this file in headless mode")
+}
+
+// audioCapture は記録に使う音声キャプチャ
+func audioCapture() func(ctx context.Context, output func(capture.Chunk)) error {
+	if headless {
+		return silentCapture
+	}
+	return capture.Capture
+}
+
+// silentCapture は音声デバイスの代わりに実時間で無音を出力する。テレメトリの時刻の基準になる。
+func silentCapture(ctx context.Context, output func(capture.Chunk)) error {
+	format := &capture.WavFormat{Channels: 1, SamplesPerSec: 8000, BitsPerSample: 16}
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	start := time.Now()
+	frames := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			elapsed := now.Sub(start)
+			n := int(elapsed.Seconds()*float64(format.SamplesPerSec)) - frames
+			frames += n
+			output(capture.Chunk{Format: format, CurrentDuration: elapsed, Buffer: make([]byte, n*2)})
+		}
+	}
+}
+
+// speechSink は読み上げの代わりに文言をイベントとファイルに出力する
+func speechSink(ctx context.Context, speechCh <-chan string) error {
+	var fp *os.File
+	if speechFile != "" {
+		f, err := os.OpenFile(speechFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fp = f
+	}
+	log.Println("speech sink started")
+	defer log.Println("speech sink stopped")
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case text, ok := <-speechCh:
+			if !ok {
+				return nil
+			}
+			now := time.Now()
+			log.Println("speech:", text)
+			api.Publish("speech", api.SpeechEvent{Text: text})
+			if fp != nil {
+				if _, err := fmt.Fprintf(fp, "%s\t%s\n", now.Format(time.RFC3339Nano), text); err != nil {
+					log.Println(err)
+				}
+			}
+		}
+	}
+}
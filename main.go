@@ -1,8 +1,6 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"flag"
 	"fmt"
@@ -14,26 +12,23 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
-	"strconv"
-	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
-	"github.com/ebitengine/oto/v3"
-	"github.com/moutend/go-wav"
-
 	"github.com/nobonobo/wrc-pacenote-mod/api"
-	"github.com/nobonobo/wrc-pacenote-mod/capture"
 	"github.com/nobonobo/wrc-pacenote-mod/config"
 	"github.com/nobonobo/wrc-pacenote-mod/easportswrc"
 	"github.com/nobonobo/wrc-pacenote-mod/ttsengine"
 )
 
-var offset = float64(10)
+var (
+	offset      = float64(10)
+	defaultMode = string(api.ModeAuto)
+)
 
 func init() {
 	flag.Float64Var(&offset, "offset", offset, "A high value causes pacenote call to start early.")
+	flag.StringVar(&defaultMode, "mode", defaultMode, "default mode for stages without settings (auto, record, play, record-while-playing, off)")
 }
 
 func getLogDir(stageLength float64) string {
@@ -41,10 +36,7 @@ func getLogDir(stageLength float64) string {
 	if stage == nil {
 		return filepath.Join(config.Config.LogDir, fmt.Sprintf("%f", stageLength))
 	}
-	return filepath.Join(config.Config.LogDir,
-		fmt.Sprintf("%02d.%s", stage.ID.Location, stage.Location),
-		fmt.Sprintf("%02d.%s", stage.ID.Stage, stage.Stage),
-	)
+	return filepath.Join(config.Config.LogDir, stage.Dir())
 }
 
 func isChange(prev, next *easportswrc.PacketEASportsWRC) bool {
@@ -57,172 +49,9 @@ func isChange(prev, next *easportswrc.PacketEASportsWRC) bool {
 		prev.VehiclePositionZ != next.VehiclePositionZ
 }
 
-func uniqueRename(fpath string) string {
-	next := fpath
-	idx := 0
-	for {
-		idx++
-		if _, err := os.Stat(next); err != nil {
-			if os.IsNotExist(err) {
-				return next
-			}
-		}
-		next = fpath + fmt.Sprintf(".%d", idx)
-	}
-}
-
-func logging(speechCh chan<- string) func(context.Context, *easportswrc.PacketEASportsWRC) error {
-	currentDuration := uint64(0)
-	setCurrent := func(v time.Duration) {
-		atomic.StoreUint64(&currentDuration, uint64(v))
-	}
-	getCurrent := func() time.Duration {
-		return time.Duration(atomic.LoadUint64(&currentDuration))
-	}
-	finished := uint32(0)
-	setFinished := func(b bool) {
-		v := uint32(0)
-		if b {
-			v = 1
-		}
-		atomic.StoreUint32(&finished, v)
-	}
-	getFinished := func() bool {
-		return atomic.LoadUint32(&finished) == 1
-	}
-	closer := func() {}
-	closeFuncs := []func(){}
-	logDir := ""
-	logFile := (*bytes.Buffer)(nil)
-	wavFile := (*wav.File)(nil)
-	lastDistance := float64(100000)
-	timeout := (*time.Timer)(nil)
-	lastPacket := (*easportswrc.PacketEASportsWRC)(nil)
-	var finishCnt = 0
-	isFinished := func(pkt *easportswrc.PacketEASportsWRC) bool {
-		if pkt == nil {
-			return false
-		}
-		finish := pkt.StageCurrentDistance > pkt.StageLength-1000 && pkt.VehicleClutch == 1.0 && pkt.VehicleBrake == 1.0
-		if finish {
-			finishCnt++
-		}
-		return finishCnt > 3
-	}
-	return func(ctx context.Context, pkt *easportswrc.PacketEASportsWRC) error {
-		defer func() {
-			lastPacket = pkt
-			lastDistance = pkt.StageCurrentDistance
-			setFinished(isFinished(lastPacket))
-		}()
-		if lastDistance != 0.0 && pkt.StageCurrentDistance == 0 {
-			defer log.Printf("packet: %v", pkt)
-
-			finishCnt = 0
-			setFinished(false)
-			if closer != nil {
-				closer()
-			}
-			closer = sync.OnceFunc(func() {
-				for _, f := range closeFuncs {
-					f()
-				}
-				closeFuncs = nil
-			})
-			logDir = getLogDir(pkt.StageLength)
-			logName := filepath.Join(logDir, "telemetry.log")
-			os.MkdirAll(logDir, 0755)
-			logName = uniqueRename(logName)
-			log.Printf("logger (re)start: %q", logName)
-			setCurrent(0)
-			logFile = bytes.NewBuffer(nil)
-			closeFuncs = append(closeFuncs, func() {
-				if getFinished() {
-					os.WriteFile(logName, logFile.Bytes(), 0o644)
-					log.Printf("log saved: %q", logName)
-					log.Printf("packet: %v", pkt)
-				} else {
-					log.Print("log save skiiped")
-				}
-				logFile = nil
-			})
-			ctx, cancel := context.WithCancel(ctx)
-			closeFuncs = append(closeFuncs, cancel)
-			timeout = time.AfterFunc(3*time.Second, closer)
-			go func(ctx context.Context) {
-				log.Println("audio recorder: started")
-				defer log.Println("audio recorder: terminated")
-				defer closer()
-				output := func(v capture.Chunk) {
-					if !getFinished() {
-						timeout.Reset(3 * time.Second)
-					}
-					setCurrent(v.CurrentDuration)
-					if wavFile == nil {
-						w, err := wav.New(
-							int(v.Format.SamplesPerSec),
-							int(v.Format.BitsPerSample),
-							int(v.Format.Channels),
-						)
-						if err != nil {
-							log.Println(err)
-							return
-						}
-						wavFile = w
-						closeFuncs = append(closeFuncs, func() {
-							b, err := wav.Marshal(wavFile)
-							if err != nil {
-								log.Println(err)
-								return
-							}
-							wavName := filepath.Join(logDir, "capture.wav")
-							wavName = uniqueRename(wavName)
-							if getFinished() {
-								if err := os.WriteFile(wavName, b, 0644); err != nil {
-									log.Println(err)
-									return
-								}
-								log.Printf("wav saved: %q", wavName)
-							} else {
-								log.Println("wav save skipped")
-							}
-							wavFile = nil
-						})
-					}
-					if _, err := wavFile.Write(v.Buffer); err != nil {
-						log.Println(err)
-						return
-					}
-				}
-				for range 3 {
-					if err := capture.Capture(ctx, output); err != nil {
-						log.Println(err)
-						time.Sleep(500 * time.Millisecond)
-						continue
-					}
-					return
-				}
-				speechCh <- "キャプチャーに失敗しました"
-			}(ctx)
-		}
-		if logFile != nil && isChange(lastPacket, pkt) {
-			fmt.Fprintf(logFile, "%d,%d,%f,%f,%f\n",
-				pkt.PacketUid,
-				getCurrent(),
-				pkt.VehiclePositionX,
-				pkt.VehiclePositionY,
-				pkt.VehiclePositionZ,
-			)
-		}
-		return nil
-	}
-}
-
 type Pacenote struct {
-	Message string  `json:"message"`
-	X       float64 `json:"x"`
-	Y       float64 `json:"y"`
-	Z       float64 `json:"z"`
+	api.Pacenote
+	Index int `json:"index"`
 }
 
 func (p *Pacenote) Distance(pkt *easportswrc.PacketEASportsWRC) float64 {
@@ -240,10 +69,17 @@ func (p *Pacenote) Distance(pkt *easportswrc.PacketEASportsWRC) float64 {
 	)
 }
 
-func pacenoteFinder(plist []*Pacenote) func(*easportswrc.PacketEASportsWRC) *Pacenote {
+// pacenoteFinder は発火すべきペースノートを探す関数と次の候補の番号を返す関数を返す
+func pacenoteFinder(plist []*Pacenote) (func(*easportswrc.PacketEASportsWRC) *Pacenote, func() int) {
 	first := true
 	lastIndex := 0
 	lastDist := float64(0)
+	next := func() int {
+		if lastIndex >= len(plist) {
+			return -1
+		}
+		return lastIndex
+	}
 	return func(pkt *easportswrc.PacketEASportsWRC) *Pacenote {
 		if lastIndex < 0 {
 			return nil
@@ -291,15 +127,19 @@ func pacenoteFinder(plist []*Pacenote) func(*easportswrc.PacketEASportsWRC) *Pac
 		// 離れたら検出
 		lastIndex++
 		return v
-	}
+	}, next
 }
 
-func normal(speechCh chan<- string) func(context.Context, *easportswrc.PacketEASportsWRC) error {
+// upcomingCount は live 配信で強調する先のペースノート数
+const upcomingCount = 3
+
+func normal(speechCh chan<- string, engine *ttsengine.Engine, fired func(*Pacenote, *easportswrc.PacketEASportsWRC), upcoming func([]*Pacenote)) func(context.Context, *easportswrc.PacketEASportsWRC) error {
 	pacenotes := []*Pacenote{}
 	lastDistance := 0.0
 	lastStageLength := -1.0
 	pacenoteInvalid := false
 	var findPacenote func(*easportswrc.PacketEASportsWRC) *Pacenote
+	var nextPacenote func() int
 	return func(ctx context.Context, pkt *easportswrc.PacketEASportsWRC) error {
 		if lastDistance != 0 && pkt.StageCurrentDistance == 0 {
 			log.Println("reload pacenote")
@@ -314,62 +154,89 @@ func normal(speechCh chan<- string) func(context.Context, *easportswrc.PacketEAS
 		if len(pacenotes) == 0 && !pacenoteInvalid {
 			dir := getLogDir(pkt.StageLength)
 			fpath := filepath.Join(dir, "pacenote.log")
-			fp, err := os.Open(fpath)
+			log.Printf("pacenote loading start: %q", fpath)
+			list, err := api.LoadPacenotes(fpath)
 			if err != nil {
 				pacenoteInvalid = true
 				return err
 			}
-			stageDict := ttsengine.NewDict()
-			log.Printf("pacenote loading start: %q", fpath)
-			scanner := bufio.NewScanner(fp)
+			messages := []string{}
 			pacenotes = []*Pacenote{}
-			for scanner.Scan() {
-				fields := strings.Split(scanner.Text(), ",")
-				if len(fields) < 3 {
-					continue
-				}
-				message := strings.Join(fields[3:], " ")
-				stageDict.Add(message)
-				x, err := strconv.ParseFloat(fields[0], 64)
-				if err != nil {
-					log.Println(err)
-					continue
-				}
-				y, err := strconv.ParseFloat(fields[1], 64)
-				if err != nil {
-					log.Println(err)
-					continue
-				}
-				z, err := strconv.ParseFloat(fields[2], 64)
-				if err != nil {
-					log.Println(err)
-					continue
-				}
-				pacenotes = append(pacenotes, &Pacenote{
-					Message: message,
-					X:       x, Y: y, Z: z,
-				})
+			for i, v := range list {
+				messages = append(messages, v.Message)
+				pacenotes = append(pacenotes, &Pacenote{Pacenote: v, Index: i})
 			}
-			fp.Close()
 			log.Println("pacenote loading completed")
-			findPacenote = pacenoteFinder(pacenotes)
+			findPacenote, nextPacenote = pacenoteFinder(pacenotes)
 			findPacenote(pkt)
-			ttsengine.SetDict(stageDict)
+			settings, err := api.LoadStageSettings(dir)
+			if err != nil {
+				log.Println(err)
+			} else if engine != nil {
+				if err := engine.UseDictionary(settings.Dictionary); err != nil {
+					log.Println(err)
+				}
+			}
+			if engine != nil {
+				engine.SetDict(engine.StageDict(messages))
+			}
 		}
 		if pkt.StageCurrentDistance == 0 {
 			return nil
 		}
 		lastDistance = pkt.StageCurrentDistance
 		p := findPacenote(pkt)
+		if upcoming != nil {
+			if idx := nextPacenote(); idx >= 0 {
+				upcoming(pacenotes[idx:min(idx+upcomingCount, len(pacenotes))])
+			} else {
+				upcoming(nil)
+			}
+		}
 		if p != nil {
 			log.Println("speech:", p.Message)
+			api.Publish("pacenote", api.FiredEvent{
+				Index:    p.Index,
+				Message:  p.Message,
+				X:        p.X,
+				Y:        p.Y,
+				Z:        p.Z,
+				Distance: pkt.StageCurrentDistance,
+			})
 			speechCh <- p.Message
+			if fired != nil {
+				fired(p, pkt)
+			}
 		}
 		return nil
 	}
 }
 
-func receiver(speechCh chan<- string) func(ctx context.Context) {
+// stageMode はステージの設定とデフォルトからモードを決める
+func stageMode(dir string) api.Mode {
+	mode := api.Mode(defaultMode)
+	settings, err := api.LoadStageSettings(dir)
+	if err != nil {
+		log.Print(err)
+	} else if settings.Mode != "" {
+		mode = settings.Mode
+	}
+	if !mode.Valid() {
+		log.Printf("invalid mode: %q", mode)
+		mode = api.ModeAuto
+	}
+	if mode == api.ModeAuto {
+		fpath := filepath.Join(dir, "pacenote.log")
+		if _, err := os.Stat(fpath); err != nil && os.IsNotExist(err) {
+			log.Printf("pacenotes.log not found: %q", fpath)
+			return api.ModeRecord
+		}
+		return api.ModePlay
+	}
+	return mode
+}
+
+func receiver(speechCh chan<- string, engine *ttsengine.Engine) func(ctx context.Context) {
 	var lastDistance = 0.0
 	return func(ctx context.Context) {
 		var dest *net.UDPAddr
@@ -390,9 +257,34 @@ func receiver(speechCh chan<- string) func(ctx context.Context) {
 			<-ctx.Done()
 			conn.Close()
 		}()
-		recording := logging(speechCh)
-		playback := normal(speechCh)
-		recodingMode := false
+		delta := newDeltaCaller(speechCh)
+		recording := newSession(speechCh, delta.Reload)
+		playRecording := newPlaybackSession(speechCh, delta.Reload)
+		timer := newStageTimer(speechCh)
+		go func() {
+			ticker := time.NewTicker(500 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case now := <-ticker.C:
+					recording.Tick(now)
+					playRecording.Tick(now)
+					timer.Tick(now)
+				}
+			}
+		}()
+		mode := api.ModeOff
+		var stage *easportswrc.Stage // 現在のステージ。カタログに無ければ nil。
+		var upcoming []*Pacenote
+		playback := normal(speechCh, engine, func(p *Pacenote, pkt *easportswrc.PacketEASportsWRC) {
+			if mode == api.ModeRecordWhilePlaying {
+				playRecording.Fire(p, pkt)
+			}
+		}, func(list []*Pacenote) {
+			upcoming = list
+		})
 		buf := make([]byte, 4096)
 		for {
 			n, _, err := conn.ReadFrom(buf)
@@ -413,34 +305,89 @@ func receiver(speechCh chan<- string) func(ctx context.Context) {
 				log.Print(err)
 				continue
 			}
+			select {
+			case <-api.ModeChanged():
+				lastDistance = 0.0
+			default:
+			}
 			if lastDistance != pkt.StageLength {
-				recodingMode = false
 				lastDistance = pkt.StageLength
 				dir := getLogDir(pkt.StageLength)
-				fpath := filepath.Join(dir, "pacenote.log")
-				if _, err := os.Stat(fpath); err != nil {
-					if os.IsNotExist(err) {
-						log.Printf("pacenotes.log not found: %q", fpath)
-						recodingMode = true
-					}
+				ev := api.StageEvent{StageLength: pkt.StageLength, Dir: dir}
+				stage = easportswrc.GetStage(pkt.StageLength)
+				if stage != nil {
+					ev.Location = stage.Location
+					ev.Stage = stage.Stage
 				}
-				if recodingMode {
-					speechCh <- "recording-mode"
+				api.Publish("stage", ev)
+				next := stageMode(dir)
+				api.SetCurrentMode(dir, next)
+				log.Printf("mode: %s -> %s", mode, next)
+				if next != api.ModeRecord {
+					recording.Abort()
 				}
+				if next != api.ModeRecordWhilePlaying {
+					playRecording.Abort()
+				}
+				timer.Stage(dir, next)
+				delta.Stage(dir, next)
+				upcoming = nil
+				mode = next
+				speechCh <- mode.Speech()
+			}
+			if mode != api.ModeOff {
+				timer.Packet(time.Now(), pkt)
 			}
-			if recodingMode {
-				if err := recording(ctx, pkt); err != nil {
+			switch mode {
+			case api.ModeRecord:
+				recording.Packet(ctx, time.Now(), pkt)
+			case api.ModePlay:
+				if err := playback(ctx, pkt); err != nil {
 					log.Print(err)
 				}
-			} else {
+				delta.Packet(pkt)
+			case api.ModeRecordWhilePlaying:
+				playRecording.Packet(ctx, time.Now(), pkt)
 				if err := playback(ctx, pkt); err != nil {
 					log.Print(err)
 				}
+				delta.Packet(pkt)
+			}
+			if api.LiveEnabled() {
+				api.PublishLive(liveSample(pkt, stage, upcoming))
 			}
 		}
 	}
 }
 
+func liveSample(pkt *easportswrc.PacketEASportsWRC, stage *easportswrc.Stage, upcoming []*Pacenote) api.LiveSample {
+	s := api.LiveSample{
+		StageLength: pkt.StageLength,
+		Distance:    pkt.StageCurrentDistance,
+		StageTime:   float64(pkt.StageCurrentTime),
+		Speed:       float64(pkt.VehicleSpeed),
+		X:           float64(pkt.VehiclePositionX),
+		Y:           float64(pkt.VehiclePositionY),
+		Z:           float64(pkt.VehiclePositionZ),
+		VelocityX:   float64(pkt.VehicleVelocityX),
+		VelocityY:   float64(pkt.VehicleVelocityY),
+		VelocityZ:   float64(pkt.VehicleVelocityZ),
+		Upcoming:    []api.LivePacenote{},
+	}
+	if stage != nil {
+		s.Location = stage.ID.Location
+		s.Stage = stage.ID.Stage
+	}
+	for _, p := range upcoming {
+		s.Upcoming = append(s.Upcoming, api.LivePacenote{
+			Index:   p.Index,
+			Message: p.Message,
+			X:       p.X, Y: p.Y, Z: p.Z,
+		})
+	}
+	return s
+}
+
 func urlLog(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Println(r.Method, r.URL.Path)
@@ -470,19 +417,38 @@ func serve(ctx context.Context, speechCh chan<- string) error {
 }
 
 func main() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	if subcommand() {
+		return
+	}
+	if err := config.Load(); err != nil {
+		log.Fatal(err)
+	}
+	config.RegisterFlags(flag.CommandLine)
+	ttsOptions := ttsengine.DefaultOptions()
+	ttsOptions.RegisterFlags(flag.CommandLine)
+	if err := config.Parse(); err != nil {
+		log.Fatal(err)
+	}
+	ttsOptions.VoiceVoxDir = config.Config.VoiceVoxDir
+	ttsOptions.Dictionary = filepath.Join(config.Config.LogDir, "dictionary.json")
+	ttsOptions.DictionaryDir = filepath.Join(config.Config.LogDir, "dictionaries")
+	ttsOptions.Install.Progress = func(p ttsengine.InstallProgress) {
+		api.SetInstallStatus(api.InstallStatus{Step: p.Step, Done: p.Done, Total: p.Total})
+	}
 	runtime.LockOSThread()
 	var wg sync.WaitGroup
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, os.Interrupt)
 	ctx, cancel := context.WithCancel(context.Background())
 
-	ctxOto, _, err := oto.NewContext(&oto.NewContextOptions{
-		SampleRate:   48000,
-		ChannelCount: 1,
-		Format:       oto.FormatSignedInt16LE,
-	})
-	if err != nil {
-		log.Fatal(err)
+	var speak speaker
+	if !headless {
+		s, err := newSpeaker()
+		if err != nil {
+			log.Fatal(err)
+		}
+		speak = s
 	}
 
 	speechCh := make(chan string, 10)
@@ -502,18 +468,25 @@ func main() {
 		}
 	}()
 
-	go receiver(speechCh)(ctx)
+	// インストールの進捗をWeb UIで見られるようにサーバー起動後にエンジンを作る
+	// 音声合成が使えなくても失敗をWeb UIで見られるように読み上げ以外は動かす
+	engine, err := ttsengine.New(ttsOptions)
+	if err != nil {
+		api.SetInstallStatus(api.InstallStatus{Step: "error", Error: err.Error()})
+		log.Println("speech engine disabled:", err)
+	} else {
+		defer engine.Close()
+		api.SetRenderer(engine.Render, ttsengine.RenderSampleRate)
+	}
 
-	for {
-		if err := ttsengine.StartEngine(ctx, ctxOto, speechCh); err != nil {
-			log.Print(err)
-		}
-		select {
-		default:
-			continue
-		case <-ctx.Done():
+	go receiver(speechCh, engine)(ctx)
+
+	if headless || engine == nil {
+		if err := speechSink(ctx, speechCh); err != nil {
+			log.Fatal(err)
 		}
-		break
+	} else {
+		speak(ctx, engine, speechCh)
 	}
 	wg.Wait()
 	time.Sleep(100 * time.Millisecond)
@@ -0,0 +1,71 @@
+package pacenote
+
+import (
+	"math"
+	"strconv"
+)
+
+// Distances は base.json にある距離の単語(m)
+var Distances = []int{
+	30, 40, 50, 60, 70, 80, 90, 100, 110, 120, 140, 160, 170, 180, 190, 200,
+	210, 220, 230, 240, 250, 260, 270, 280, 290, 300, 310, 320, 330, 340, 350,
+	360, 370, 380, 390, 400, 500,
+}
+
+// RoundDistance は m を available のうち最も近い値にする。最小値の半分未満か最大値の1.5倍を超えるなら 0 を返す。
+func RoundDistance(m float64, available []int) int {
+	res, best := 0, math.Inf(1)
+	for _, v := range available {
+		if d := math.Abs(m - float64(v)); d < best {
+			res, best = v, d
+		}
+	}
+	if res == 0 {
+		return 0
+	}
+	min, max := res, res
+	for _, v := range available {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	if m < float64(min)/2 || m > float64(max)*1.5 {
+		return 0
+	}
+	return res
+}
+
+// LastDistance は最後のコールの距離とその単語の位置を返す。距離が無ければ位置は -1。
+// 最後がつなぎの場合や単語が無い場合は距離を付けられないので ok は false。
+func LastDistance(tokens []Token) (distance, index int, ok bool) {
+	if len(tokens) == 0 {
+		return 0, -1, false
+	}
+	last := tokens[len(tokens)-1]
+	switch last.Kind {
+	case KindLink:
+		return 0, -1, false
+	case KindDistance:
+		return last.Distance, len(tokens) - 1, true
+	}
+	return 0, -1, true
+}
+
+// SetDistance は最後のコールの距離を d にした単語の並びを返す
+func SetDistance(tokens []Token, d int) []Token {
+	_, index, ok := LastDistance(tokens)
+	if !ok {
+		return tokens
+	}
+	t := Token{Kind: KindDistance, Text: strconv.Itoa(d), Distance: d, Pos: -1}
+	res := append([]Token{}, tokens...)
+	if index < 0 {
+		return append(res, t)
+	}
+	t.Pos = res[index].Pos
+	res[index] = t
+	return res
+}
@@ -0,0 +1,165 @@
+package pacenote
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Kind は単語の種類
+type Kind string
+
+const (
+	KindCorner   Kind = "corner"   // 3-left など
+	KindModifier Kind = "modifier" // tightens など直前のコーナーにかかる単語
+	KindLink     Kind = "link"     // into, and
+	KindDistance Kind = "distance" // 次のコールまでの距離(m)
+	KindWord     Kind = "word"     // jump, crest などその他の単語
+)
+
+// Severities はコーナーの深さ。数字は小さいほどきつい。
+var Severities = []string{"1", "2", "3", "4", "5", "6", "slight", "square", "hp", "open-hp", "acute-hp"}
+
+// Directions はコーナーの向き
+var Directions = []string{"left", "right"}
+
+// Links は次のコールへのつなぎ
+var Links = []string{"into", "and"}
+
+// Modifiers は直前のコーナーにかかる単語
+var Modifiers = []string{
+	"tightens", "opens", "tighten-1", "tighten-2", "tighten-3", "tighten-4", "tighten-5", "open-6",
+	"long", "half-long", "very-long", "extra-long", "shorts",
+	"dont-cut", "cut", "keep-in", "early", "late",
+}
+
+// compact 式の略記
+var (
+	compactSeverity = map[string]string{
+		"sl": "slight", "sq": "square", "hp": "hp", "ohp": "open-hp", "ahp": "acute-hp",
+	}
+	compactLink = map[string]string{">": "into", "&": "and"}
+	// 3L, hpR+, sqL- など。+ は tightens、- は opens。
+	compactCorner = regexp.MustCompile(`^(?i)([1-6]|sl|sq|hp|ohp|ahp)([lr])([+-]?)$`)
+	// left-3, L3 など向きが先の書き方
+	directionFirst = regexp.MustCompile(`^(?i)(left|right|l|r)-?([1-6])$`)
+	// 3left, hairpin-left など
+	severityFirst = regexp.MustCompile(`^(?i)([1-6]|slight|square|hp|hairpin|open-hp|open-hairpin|acute-hp|acute-hairpin)-?(left|right)$`)
+)
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func direction(s string) string {
+	switch strings.ToLower(s) {
+	case "l", "left":
+		return "left"
+	case "r", "right":
+		return "right"
+	}
+	return ""
+}
+
+func severity(s string) string {
+	s = strings.ToLower(s)
+	if v, ok := compactSeverity[s]; ok {
+		return v
+	}
+	s = strings.ReplaceAll(s, "hairpin", "hp")
+	if contains(Severities, s) {
+		return s
+	}
+	return ""
+}
+
+// Corner はコーナー1つ
+type Corner struct {
+	Severity  string `json:"severity"`
+	Direction string `json:"direction"`
+}
+
+func (c Corner) String() string {
+	return c.Severity + "-" + c.Direction
+}
+
+// Rank は数字のコーナーの深さ。数字以外は 0。
+func (c Corner) Rank() int {
+	n, _ := strconv.Atoi(c.Severity)
+	return n
+}
+
+// Token は正規化した単語
+type Token struct {
+	Kind     Kind    `json:"kind"`
+	Text     string  `json:"text"`
+	Corner   *Corner `json:"corner,omitempty"`
+	Distance int     `json:"distance,omitempty"`
+	Pos      int     `json:"pos"` // 元の文言での単語の位置
+}
+
+// classify は単語1つを正規化する。compact 式の + と - は修飾語のトークンも返す。
+func classify(word string, pos int) []Token {
+	if m := compactCorner.FindStringSubmatch(word); m != nil {
+		c := &Corner{Severity: severity(m[1]), Direction: direction(m[2])}
+		res := []Token{{Kind: KindCorner, Text: c.String(), Corner: c, Pos: pos}}
+		switch m[3] {
+		case "+":
+			res = append(res, Token{Kind: KindModifier, Text: "tightens", Pos: pos})
+		case "-":
+			res = append(res, Token{Kind: KindModifier, Text: "opens", Pos: pos})
+		}
+		return res
+	}
+	if m := directionFirst.FindStringSubmatch(word); m != nil {
+		c := &Corner{Severity: m[2], Direction: direction(m[1])}
+		return []Token{{Kind: KindCorner, Text: c.String(), Corner: c, Pos: pos}}
+	}
+	if m := severityFirst.FindStringSubmatch(word); m != nil {
+		c := &Corner{Severity: severity(m[1]), Direction: direction(m[2])}
+		return []Token{{Kind: KindCorner, Text: c.String(), Corner: c, Pos: pos}}
+	}
+	if v, ok := compactLink[word]; ok {
+		return []Token{{Kind: KindLink, Text: v, Pos: pos}}
+	}
+	lower := strings.ToLower(word)
+	switch {
+	case contains(Links, lower):
+		return []Token{{Kind: KindLink, Text: lower, Pos: pos}}
+	case contains(Modifiers, lower):
+		return []Token{{Kind: KindModifier, Text: lower, Pos: pos}}
+	}
+	if n, err := strconv.Atoi(word); err == nil && n > 6 {
+		return []Token{{Kind: KindDistance, Text: word, Distance: n, Pos: pos}}
+	}
+	return []Token{{Kind: KindWord, Text: word, Pos: pos}}
+}
+
+// Tokenize は文言を正規化した単語に分ける。"3 left" や "left 3" のような2語の書き方も1つのコーナーにする。
+func Tokenize(text string) []Token {
+	words := strings.Fields(text)
+	res := []Token{}
+	for i := 0; i < len(words); i++ {
+		if i+1 < len(words) {
+			if s, d := severity(words[i]), direction(words[i+1]); s != "" && d != "" && len(words[i+1]) > 1 {
+				c := &Corner{Severity: s, Direction: d}
+				res = append(res, Token{Kind: KindCorner, Text: c.String(), Corner: c, Pos: i})
+				i++
+				continue
+			}
+			if d, s := direction(words[i]), words[i+1]; d != "" && len(words[i]) > 1 && len(s) == 1 && s >= "1" && s <= "6" {
+				c := &Corner{Severity: s, Direction: d}
+				res = append(res, Token{Kind: KindCorner, Text: c.String(), Corner: c, Pos: i})
+				i++
+				continue
+			}
+		}
+		res = append(res, classify(words[i], i)...)
+	}
+	return res
+}
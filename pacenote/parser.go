@@ -0,0 +1,132 @@
+package pacenote
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Call はコール1つ。文法は [単語...] [コーナー [修飾語 | 単語]...] [つなぎ | 距離]。
+type Call struct {
+	Before    []string `json:"before,omitempty"` // コーナーより前の単語(unseen など)
+	Corner    *Corner  `json:"corner,omitempty"`
+	Modifiers []string `json:"modifiers,omitempty"`
+	After     []string `json:"after,omitempty"`    // コーナーより後の単語(over-crest など)
+	Link      string   `json:"link,omitempty"`     // 次のコールへのつなぎ
+	Distance  int      `json:"distance,omitempty"` // 次のコールまでの距離(m)
+}
+
+// closed は次の単語から新しいコールになるかどうか
+func (c *Call) closed() bool {
+	return c.Link != "" || c.Distance != 0
+}
+
+// Issue は文言の誤り
+type Issue struct {
+	Pos     int    `json:"pos"` // 元の文言での単語の位置
+	Token   string `json:"token"`
+	Message string `json:"message"`
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("%d:%s: %s", i.Pos, i.Token, i.Message)
+}
+
+// Note はペースノート1行分の解析結果
+type Note struct {
+	Tokens []Token `json:"tokens"`
+	Calls  []Call  `json:"calls"`
+	Issues []Issue `json:"issues"`
+}
+
+// Valid は誤りが無いかどうか
+func (n *Note) Valid() bool {
+	return len(n.Issues) == 0
+}
+
+// Parse は文言をコールの並びにする。誤りがあっても解析は最後まで続ける。
+func Parse(text string) *Note {
+	n := &Note{Tokens: Tokenize(text), Calls: []Call{}, Issues: []Issue{}}
+	issue := func(t Token, format string, args ...any) {
+		n.Issues = append(n.Issues, Issue{Pos: t.Pos, Token: t.Text, Message: fmt.Sprintf(format, args...)})
+	}
+	var cur *Call
+	next := func() *Call {
+		n.Calls = append(n.Calls, Call{})
+		cur = &n.Calls[len(n.Calls)-1]
+		return cur
+	}
+	for _, t := range n.Tokens {
+		switch t.Kind {
+		case KindCorner:
+			if cur == nil || cur.closed() || cur.Corner != nil {
+				next()
+			}
+			cur.Corner = t.Corner
+		case KindModifier:
+			if cur == nil || cur.closed() || cur.Corner == nil {
+				issue(t, "modifier without corner")
+				if cur == nil || cur.closed() {
+					next()
+				}
+				cur.After = append(cur.After, t.Text)
+				continue
+			}
+			checkModifier(cur, t, issue)
+			cur.Modifiers = append(cur.Modifiers, t.Text)
+		case KindLink:
+			switch {
+			case cur == nil:
+				issue(t, "link without preceding call")
+			case cur.Link != "":
+				issue(t, "consecutive links")
+			case cur.Distance != 0:
+				issue(t, "link after distance")
+			default:
+				cur.Link = t.Text
+			}
+		case KindDistance:
+			switch {
+			case cur == nil:
+				issue(t, "distance without preceding call")
+			case cur.Distance != 0:
+				issue(t, "consecutive distances")
+			case cur.Link != "":
+				issue(t, "distance after link")
+			default:
+				cur.Distance = t.Distance
+			}
+		default:
+			if cur == nil || cur.closed() {
+				next()
+			}
+			if cur.Corner == nil {
+				cur.Before = append(cur.Before, t.Text)
+			} else {
+				cur.After = append(cur.After, t.Text)
+			}
+		}
+	}
+	if cur != nil && cur.Link != "" {
+		issue(n.Tokens[len(n.Tokens)-1], "link without following call")
+	}
+	return n
+}
+
+// checkModifier は tighten-N や open-6 がコーナーの深さと矛盾しないかを調べる
+func checkModifier(c *Call, t Token, issue func(Token, string, ...any)) {
+	rank := c.Corner.Rank()
+	if rank == 0 {
+		return
+	}
+	if v, ok := strings.CutPrefix(t.Text, "tighten-"); ok {
+		if n, _ := strconv.Atoi(v); n >= rank {
+			issue(t, "does not tighten from %s", c.Corner)
+		}
+	}
+	if v, ok := strings.CutPrefix(t.Text, "open-"); ok {
+		if n, _ := strconv.Atoi(v); n <= rank {
+			issue(t, "does not open from %s", c.Corner)
+		}
+	}
+}
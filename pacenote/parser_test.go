@@ -0,0 +1,109 @@
+package pacenote
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"3-left into 4-right 100", []string{"3-left", "into", "4-right", "100"}},
+		{"3L > 4R+ 100", []string{"3-left", "into", "4-right", "tightens", "100"}},
+		{"left 3 & hairpin right", []string{"3-left", "and", "hp-right"}},
+		{"L3 3left ahpL- ohpR", []string{"3-left", "3-left", "acute-hp-left", "opens", "open-hp-right"}},
+		{"unseen 6 left Long", []string{"unseen", "6-left", "long"}},
+		{"sq r", []string{"sq", "r"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got := []string{}
+			for _, tok := range Tokenize(tt.text) {
+				got = append(got, tok.Text)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text   string
+		calls  []Call
+		issues []string
+	}{
+		{
+			text: "3L > 4R+ 100",
+			calls: []Call{
+				{Corner: &Corner{"3", "left"}, Link: "into"},
+				{Corner: &Corner{"4", "right"}, Modifiers: []string{"tightens"}, Distance: 100},
+			},
+		},
+		{
+			text:  "unseen 6-left tighten-5 over-crest 150",
+			calls: []Call{{Before: []string{"unseen"}, Corner: &Corner{"6", "left"}, Modifiers: []string{"tighten-5"}, After: []string{"over-crest"}, Distance: 150}},
+		},
+		{
+			text:   "2-right tighten-2",
+			calls:  []Call{{Corner: &Corner{"2", "right"}, Modifiers: []string{"tighten-2"}}},
+			issues: []string{"1:tighten-2: does not tighten from 2-right"},
+		},
+		{
+			text:   "tightens 3-left",
+			calls:  []Call{{Corner: &Corner{"3", "left"}, After: []string{"tightens"}}},
+			issues: []string{"0:tightens: modifier without corner"},
+		},
+		{
+			text:   "into 3-left",
+			calls:  []Call{{Corner: &Corner{"3", "left"}}},
+			issues: []string{"0:into: link without preceding call"},
+		},
+		{
+			text:   "3-left into",
+			calls:  []Call{{Corner: &Corner{"3", "left"}, Link: "into"}},
+			issues: []string{"1:into: link without following call"},
+		},
+		{
+			text:   "3-left into and 4-right",
+			calls:  []Call{{Corner: &Corner{"3", "left"}, Link: "into"}, {Corner: &Corner{"4", "right"}}},
+			issues: []string{"2:and: consecutive links"},
+		},
+		{
+			text:   "3-left 100 into 4-right",
+			calls:  []Call{{Corner: &Corner{"3", "left"}, Distance: 100}, {Corner: &Corner{"4", "right"}}},
+			issues: []string{"2:into: link after distance"},
+		},
+		{
+			text:   "3-left 100 200",
+			calls:  []Call{{Corner: &Corner{"3", "left"}, Distance: 100}},
+			issues: []string{"2:200: consecutive distances"},
+		},
+		{
+			text:   "100 3-left",
+			calls:  []Call{{Corner: &Corner{"3", "left"}}},
+			issues: []string{"0:100: distance without preceding call"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			n := Parse(tt.text)
+			if !reflect.DeepEqual(n.Calls, tt.calls) {
+				t.Errorf("calls = %+v, want %+v", n.Calls, tt.calls)
+			}
+			issues := []string{}
+			for _, i := range n.Issues {
+				issues = append(issues, i.String())
+			}
+			if len(issues)+len(tt.issues) > 0 && !reflect.DeepEqual(issues, tt.issues) {
+				t.Errorf("issues = %q, want %q", issues, tt.issues)
+			}
+			if n.Valid() != (len(tt.issues) == 0) {
+				t.Errorf("Valid() = %v", n.Valid())
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package pacenote
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Style は文言の書き方
+type Style string
+
+const (
+	StyleWords   Style = "words"   // 辞書の単語のまま(3-left into 4-right 100)。読み上げに使う。
+	StyleCompact Style = "compact" // 略記(3L > 4R 100)。編集時の入力向け。
+)
+
+func (s Style) Valid() bool {
+	switch s {
+	case StyleWords, StyleCompact:
+		return true
+	}
+	return false
+}
+
+func compactCornerText(c *Corner, modifier string) string {
+	sev := c.Severity
+	for k, v := range compactSeverity {
+		if v == sev {
+			sev = k
+		}
+	}
+	s := sev + strings.ToUpper(c.Direction[:1])
+	switch modifier {
+	case "tightens":
+		s += "+"
+	case "opens":
+		s += "-"
+	}
+	return s
+}
+
+// Format は単語の並びを style の書き方にする
+func Format(tokens []Token, style Style) string {
+	res := []string{}
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if style != StyleCompact {
+			res = append(res, t.Text)
+			continue
+		}
+		switch t.Kind {
+		case KindCorner:
+			modifier := ""
+			if i+1 < len(tokens) && tokens[i+1].Kind == KindModifier {
+				if m := tokens[i+1].Text; m == "tightens" || m == "opens" {
+					modifier = m
+					i++
+				}
+			}
+			res = append(res, compactCornerText(t.Corner, modifier))
+		case KindLink:
+			for k, v := range compactLink {
+				if v == t.Text {
+					res = append(res, k)
+				}
+			}
+		default:
+			res = append(res, t.Text)
+		}
+	}
+	return strings.Join(res, " ")
+}
+
+// Normalize は文言を style の書き方に変換する
+func Normalize(text string, style Style) (string, error) {
+	if !style.Valid() {
+		return "", fmt.Errorf("invalid style: %q", style)
+	}
+	return Format(Tokenize(text), style), nil
+}
@@ -0,0 +1,35 @@
+package pacenote
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		text  string
+		style Style
+		want  string
+	}{
+		{"3L > 4R+ 100", StyleWords, "3-left into 4-right tightens 100"},
+		{"left 3 and hairpin right", StyleCompact, "3L & hpR"},
+		{"acute-hp-left opens into square right", StyleCompact, "ahpL- > sqR"},
+		{"6-left tighten-5 over-crest 150", StyleCompact, "6L tighten-5 over-crest 150"},
+		{"  unseen   crest  ", StyleWords, "unseen crest"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.style)+"/"+tt.text, func(t *testing.T) {
+			got, err := Normalize(tt.text, tt.style)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Normalize(%q, %s) = %q, want %q", tt.text, tt.style, got, tt.want)
+			}
+			// 変換した文言をもう一度変換しても変わらない
+			if again, _ := Normalize(got, tt.style); again != got {
+				t.Errorf("Normalize(%q, %s) = %q, not stable", got, tt.style, again)
+			}
+		})
+	}
+	if _, err := Normalize("3-left", "fancy"); err == nil {
+		t.Error("invalid style was accepted")
+	}
+}
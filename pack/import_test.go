@@ -0,0 +1,76 @@
+package pack_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nobonobo/wrc-pacenote-mod/api"
+	"github.com/nobonobo/wrc-pacenote-mod/easportswrc"
+	"github.com/nobonobo/wrc-pacenote-mod/pack"
+)
+
+func TestImportNewTake(t *testing.T) {
+	stage := easportswrc.GetStageByID(1, 1)
+	src := t.TempDir()
+	srcDir := filepath.Join(src, stage.Dir())
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(srcDir, "pacenote.log"), []byte("1.000000,2.000000,3.000000,3-left\n"), 0o644)
+	os.WriteFile(filepath.Join(srcDir, "regions.log"), []byte("0.500000,1.000000,3-left\n"), 0o644)
+	buf := &bytes.Buffer{}
+	if _, err := pack.Export(buf, src, []*easportswrc.Stage{stage}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 既存のステージには記録したテイク 0 と 1 がある
+	dst := t.TempDir()
+	dir := filepath.Join(dst, stage.Dir())
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"telemetry.log":   "1,0,0,0,0\n",
+		"pacenote.log":    "0.000000,0.000000,0.000000,mine\n",
+		"regions.log":     "0.100000,0.200000,mine\n",
+		"telemetry.log.1": "1,0,0,0,0\n",
+	} {
+		os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644)
+	}
+	for _, want := range []int{2, 3} {
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err := pack.Import(zr, dst, pack.ConflictNew)
+		if err != nil {
+			t.Fatal(err)
+		}
+		suffix := api.TakeSuffix(want)
+		if got, wantFiles := report.Imported, []string{filepath.Join(stage.Dir(), "pacenote.log"+suffix), filepath.Join(stage.Dir(), "regions.log"+suffix)}; !reflect.DeepEqual(got, wantFiles) {
+			t.Errorf("imported = %q, want %q", got, wantFiles)
+		}
+		// api のテイク一覧と読み込みで扱える
+		entries := api.ListTakeEntries(dir)
+		last := entries[len(entries)-1]
+		if last.Take != want || !reflect.DeepEqual(last.Files, []string{"pacenote.log", "regions.log"}) {
+			t.Errorf("takes = %+v, want take %d with the imported files", entries, want)
+		}
+		pacenotes, err := api.LoadPacenotes(filepath.Join(dir, "pacenote.log"+suffix))
+		if err != nil || len(pacenotes) != 1 || pacenotes[0].Message != "3-left" {
+			t.Errorf("pacenotes = %+v, %v", pacenotes, err)
+		}
+		regions, err := api.LoadRegions(filepath.Join(dir, "regions.log"+suffix))
+		if err != nil || !reflect.DeepEqual(regions, api.Regions{{Start: 0.5, End: 1, Content: "3-left"}}) {
+			t.Errorf("regions = %+v, %v", regions, err)
+		}
+	}
+	// 既存のペースノートはそのまま
+	if b, _ := os.ReadFile(filepath.Join(dir, "pacenote.log")); string(b) != "0.000000,0.000000,0.000000,mine\n" {
+		t.Errorf("pacenote.log = %q", b)
+	}
+}
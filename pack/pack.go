@@ -0,0 +1,453 @@
+package pack
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nobonobo/wrc-pacenote-mod/easportswrc"
+)
+
+// Version はパックの形式のバージョン。パックはステージ単位でペースノートをまとめた zip。
+const Version = 1
+
+// Files はパックに含めるステージのファイル。settings.json はモードなど環境毎の設定なので含めない。
+var Files = []string{"pacenote.log", "regions.log"}
+
+// settingsFile は以前のパックに含まれていたステージ設定。読み込み時は名前付き辞書の名前だけを使う。
+const settingsFile = "settings.json"
+
+// Conflict は読み込み先に同じファイルがあった時の扱い
+type Conflict string
+
+const (
+	ConflictKeep    Conflict = "keep"    // 既存のファイルを残す
+	ConflictReplace Conflict = "replace" // 上書きする
+	ConflictNew     Conflict = "new"     // 別テイクとして保存する(単語定義は既存を残す)
+)
+
+func (c Conflict) Valid() bool {
+	switch c {
+	case ConflictKeep, ConflictReplace, ConflictNew:
+		return true
+	}
+	return false
+}
+
+// StageEntry はパック内の1ステージ分
+type StageEntry struct {
+	Location     int      `json:"location"`
+	Stage        int      `json:"stage"`
+	LocationName string   `json:"locationName"`
+	StageName    string   `json:"stageName"`
+	Files        []string `json:"files"`
+	Dictionary   string   `json:"dictionary,omitempty"` // ステージで使う名前付き辞書
+}
+
+func (e *StageEntry) dir() string {
+	return path.Join("stages", strconv.Itoa(e.Location), strconv.Itoa(e.Stage))
+}
+
+// Manifest はパックの manifest.json
+type Manifest struct {
+	Version    int                        `json:"version"`
+	Created    time.Time                  `json:"created"`
+	Stages     []StageEntry               `json:"stages"`
+	Dictionary map[string]json.RawMessage `json:"dictionary,omitempty"` // ペースノートで使う単語の辞書定義
+	// Dictionaries はステージが使う名前付き辞書の単語定義
+	Dictionaries map[string]map[string]json.RawMessage `json:"dictionaries,omitempty"`
+}
+
+// Select は "ロケーション番号" または "ロケーション番号/ステージ番号" からステージを選ぶ
+func Select(spec string) ([]*easportswrc.Stage, error) {
+	parts := strings.Split(strings.Trim(filepath.ToSlash(spec), "/"), "/")
+	loc, err := strconv.Atoi(parts[0])
+	if err != nil || loc < 1 || loc > len(easportswrc.Locations) {
+		return nil, fmt.Errorf("location not found: %q", spec)
+	}
+	switch len(parts) {
+	case 1:
+		res := []*easportswrc.Stage{}
+		for i := range easportswrc.Locations[loc-1].Stages {
+			res = append(res, easportswrc.GetStageByID(loc, i+1))
+		}
+		return res, nil
+	case 2:
+		ss, err := strconv.Atoi(parts[1])
+		if err == nil {
+			if stage := easportswrc.GetStageByID(loc, ss); stage != nil {
+				return []*easportswrc.Stage{stage}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("stage not found: %q", spec)
+}
+
+func loadDictionary(fpath string) (map[string]json.RawMessage, error) {
+	dict := map[string]json.RawMessage{}
+	b, err := os.ReadFile(fpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return dict, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &dict); err != nil {
+		return nil, err
+	}
+	return dict, nil
+}
+
+// engineKey は名前付き辞書の外部エンジン設定のキー(ttsengine.EngineKey と同じ)。
+// 任意のコマンドを実行させられるのでパックには含めず、読み込みもしない。
+const engineKey = "$engine"
+
+// validName は名前付き辞書の名前として使えるかどうか
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\:`)
+}
+
+// loadSettings はステージの settings.json を読み込む。api.StageSettings 以外の項目も保つため map で扱う。
+func loadSettings(dir string) (map[string]json.RawMessage, error) {
+	return loadDictionary(filepath.Join(dir, settingsFile))
+}
+
+// settingsDictionary は settings.json の名前付き辞書の名前
+func settingsDictionary(settings map[string]json.RawMessage) string {
+	var name string
+	if v, ok := settings["dictionary"]; ok {
+		json.Unmarshal(v, &name)
+	}
+	return name
+}
+
+// stageDictionary はステージの settings.json で指定された名前付き辞書を読み込む
+func stageDictionary(logDir, dir string) (map[string]json.RawMessage, string, error) {
+	settings, err := loadSettings(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	name := settingsDictionary(settings)
+	if !validName(name) {
+		return nil, "", nil
+	}
+	dict, err := loadDictionary(filepath.Join(logDir, "dictionaries", name+".json"))
+	return dict, name, err
+}
+
+// importDictionary はステージに名前付き辞書の設定が無い場合だけ name を設定する。モードは読み込まない。
+func importDictionary(dir, stageDir, name string, report *Report) error {
+	if name == "" {
+		return nil
+	}
+	if !validName(name) {
+		report.skip("%s: invalid dictionary name %q", stageDir, name)
+		return nil
+	}
+	settings, err := loadSettings(dir)
+	if err != nil {
+		return err
+	}
+	if local := settingsDictionary(settings); local != "" {
+		if local != name {
+			report.skip("%s: keep dictionary %q (pack: %q)", stageDir, local, name)
+		}
+		return nil
+	}
+	settings["dictionary"], _ = json.Marshal(name)
+	b, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, settingsFile), b, 0o644); err != nil {
+		return err
+	}
+	report.Imported = append(report.Imported, fmt.Sprintf("%s: dictionary %q", stageDir, name))
+	return nil
+}
+
+// words は pacenote.log で使われている単語
+func words(fpath string) []string {
+	b, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil
+	}
+	res := []string{}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Split(strings.TrimSpace(line), ",")
+		if len(fields) < 4 {
+			continue
+		}
+		res = append(res, strings.Fields(strings.Join(fields[3:], " "))...)
+	}
+	return res
+}
+
+// Export はステージのファイルと使っている辞書定義をパックにして w に書き出す
+func Export(w io.Writer, logDir string, stages []*easportswrc.Stage) (*Manifest, error) {
+	dict, err := loadDictionary(filepath.Join(logDir, "dictionary.json"))
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{Version: Version, Created: time.Now(), Stages: []StageEntry{}, Dictionary: map[string]json.RawMessage{}}
+	zw := zip.NewWriter(w)
+	for _, stage := range stages {
+		dir := filepath.Join(logDir, stage.Dir())
+		entry := StageEntry{
+			Location:     stage.ID.Location,
+			Stage:        stage.ID.Stage,
+			LocationName: stage.Location,
+			StageName:    stage.Stage,
+			Files:        []string{},
+		}
+		for _, name := range Files {
+			b, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, err
+			}
+			f, err := zw.Create(path.Join(entry.dir(), name))
+			if err != nil {
+				return nil, err
+			}
+			if _, err := f.Write(b); err != nil {
+				return nil, err
+			}
+			entry.Files = append(entry.Files, name)
+		}
+		if len(entry.Files) == 0 {
+			continue
+		}
+		named, name, err := stageDictionary(logDir, dir)
+		if err != nil {
+			return nil, err
+		}
+		entry.Dictionary = name
+		for _, word := range words(filepath.Join(dir, "pacenote.log")) {
+			if word == engineKey {
+				continue
+			}
+			if v, ok := dict[word]; ok {
+				m.Dictionary[word] = v
+			}
+			if v, ok := named[word]; ok {
+				if m.Dictionaries == nil {
+					m.Dictionaries = map[string]map[string]json.RawMessage{}
+				}
+				if m.Dictionaries[name] == nil {
+					m.Dictionaries[name] = map[string]json.RawMessage{}
+				}
+				m.Dictionaries[name][word] = v
+			}
+		}
+		m.Stages = append(m.Stages, entry)
+	}
+	if len(m.Stages) == 0 {
+		return nil, fmt.Errorf("no pacenotes to export")
+	}
+	f, err := zw.Create("manifest.json")
+	if err != nil {
+		return nil, err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		return nil, err
+	}
+	return m, zw.Close()
+}
+
+// Report は読み込み結果
+type Report struct {
+	Imported   []string `json:"imported"`
+	Skipped    []string `json:"skipped"`
+	Dictionary int      `json:"dictionary"` // 追加・更新した辞書の単語数
+}
+
+func (r *Report) skip(format string, args ...any) {
+	r.Skipped = append(r.Skipped, fmt.Sprintf(format, args...))
+}
+
+func readFile(zr *zip.Reader, name string) ([]byte, error) {
+	f, err := zr.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// takeFiles はテイク毎に連番サフィックスを付けるファイル(api.TakeFiles と同じ)
+var takeFiles = []string{"telemetry.log", "telemetry.csv.gz", "capture.wav", "take.json", "playback.log", "pacenote.log", "regions.log"}
+
+// exists は names のどれかに suffix を付けたファイルがあるかどうか
+func exists(dir string, names []string, suffix string) bool {
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(dir, name+suffix)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// newTake はどのテイクのファイルも無い連番サフィックス(api.TakeSuffix と同じ形式)を返す
+func newTake(dir string) string {
+	for n := 1; ; n++ {
+		if suffix := fmt.Sprintf(".%d", n); !exists(dir, takeFiles, suffix) {
+			return suffix
+		}
+	}
+}
+
+// Import はパックを読み込み、カタログと照合したステージのファイルと辞書定義を logDir に展開する
+func Import(zr *zip.Reader, logDir string, conflict Conflict) (*Report, error) {
+	if !conflict.Valid() {
+		return nil, fmt.Errorf("invalid conflict policy: %q", conflict)
+	}
+	b, err := readFile(zr, "manifest.json")
+	if err != nil {
+		return nil, fmt.Errorf("manifest.json: %w", err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("manifest.json: %w", err)
+	}
+	if m.Version > Version {
+		return nil, fmt.Errorf("unsupported pack version: %d", m.Version)
+	}
+	report := &Report{Imported: []string{}, Skipped: []string{}}
+	for _, entry := range m.Stages {
+		stage := easportswrc.GetStageByID(entry.Location, entry.Stage)
+		if stage == nil {
+			report.skip("%d/%d: stage not found", entry.Location, entry.Stage)
+			continue
+		}
+		if stage.Location != entry.LocationName || stage.Stage != entry.StageName {
+			report.skip("%d/%d: stage mismatch %q/%q != %q/%q", entry.Location, entry.Stage,
+				entry.LocationName, entry.StageName, stage.Location, stage.Stage)
+			continue
+		}
+		dir := filepath.Join(logDir, stage.Dir())
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		files := []string{}
+		dictionary := entry.Dictionary
+		for _, name := range entry.Files {
+			if name == settingsFile {
+				// 以前のパック。名前付き辞書の名前だけを使う。
+				if dictionary == "" {
+					b, err := readFile(zr, path.Join(entry.dir(), name))
+					if err != nil {
+						return nil, err
+					}
+					settings := map[string]json.RawMessage{}
+					if err := json.Unmarshal(b, &settings); err != nil {
+						return nil, fmt.Errorf("%s: %w", path.Join(entry.dir(), name), err)
+					}
+					dictionary = settingsDictionary(settings)
+				}
+				continue
+			}
+			if !allowed(name) {
+				report.skip("%s: unknown file %q", stage.Dir(), name)
+				continue
+			}
+			files = append(files, name)
+		}
+		suffix := ""
+		if conflict == ConflictNew && exists(dir, files, "") {
+			// API のテイク一覧と regions/take の ?take= で読める
+			suffix = newTake(dir)
+		}
+		for _, name := range files {
+			dst := filepath.Join(dir, name+suffix)
+			if _, err := os.Stat(dst); err == nil && conflict == ConflictKeep {
+				report.skip("%s: keep existing", filepath.Join(stage.Dir(), name))
+				continue
+			}
+			b, err := readFile(zr, path.Join(entry.dir(), name))
+			if err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(dst, b, 0o644); err != nil {
+				return nil, err
+			}
+			report.Imported = append(report.Imported, filepath.Join(stage.Dir(), name+suffix))
+		}
+		if err := importDictionary(dir, stage.Dir(), dictionary, report); err != nil {
+			return nil, err
+		}
+	}
+	delete(m.Dictionary, engineKey)
+	n, err := mergeDictionary(filepath.Join(logDir, "dictionary.json"), m.Dictionary, conflict)
+	if err != nil {
+		return nil, err
+	}
+	report.Dictionary += n
+	for name, words := range m.Dictionaries {
+		if !validName(name) {
+			report.skip("dictionary %q: invalid name", name)
+			continue
+		}
+		if _, ok := words[engineKey]; ok {
+			report.skip("dictionary %q: %s is not imported", name, engineKey)
+			delete(words, engineKey)
+		}
+		dir := filepath.Join(logDir, "dictionaries")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		n, err := mergeDictionary(filepath.Join(dir, name+".json"), words, conflict)
+		if err != nil {
+			return nil, err
+		}
+		report.Dictionary += n
+	}
+	return report, nil
+}
+
+// mergeDictionary は辞書ファイルに単語定義を加え、加えた数を返す
+func mergeDictionary(fpath string, words map[string]json.RawMessage, conflict Conflict) (int, error) {
+	if len(words) == 0 {
+		return 0, nil
+	}
+	dict, err := loadDictionary(fpath)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for k, v := range words {
+		if _, ok := dict[k]; ok && conflict != ConflictReplace {
+			continue
+		}
+		dict[k] = v
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	b, err := json.MarshalIndent(dict, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	return n, os.WriteFile(fpath, b, 0o644)
+}
+
+func allowed(name string) bool {
+	for _, v := range Files {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,262 @@
+package pack
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nobonobo/wrc-pacenote-mod/easportswrc"
+)
+
+func writeFile(t *testing.T, fpath, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fpath, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readDictionary(t *testing.T, fpath string) map[string]json.RawMessage {
+	t.Helper()
+	dict, err := loadDictionary(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dict
+}
+
+// packZip は manifest と files からパックを作る
+func packZip(t *testing.T, m *Manifest, files map[string]string) *zip.Reader {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files["manifest.json"] = string(b)
+	for name, content := range files {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+func TestExportImport(t *testing.T) {
+	src := t.TempDir()
+	stage := easportswrc.GetStageByID(1, 1)
+	dir := filepath.Join(src, stage.Dir())
+	writeFile(t, filepath.Join(dir, "pacenote.log"), "1.0,2.0,3.0,3-left into crest\n4.0,5.0,6.0,$engine\n")
+	writeFile(t, filepath.Join(dir, "settings.json"), `{"mode":"record","dictionary":"mine"}`)
+	writeFile(t, filepath.Join(dir, "telemetry.log"), "not packed\n")
+	writeFile(t, filepath.Join(src, "dictionary.json"), `{"crest":{"text":"クレスト"},"unused":{"text":"x"},"$engine":{"command":"rm"}}`)
+	writeFile(t, filepath.Join(src, "dictionaries", "mine.json"), `{"3-left":{"text":"左3"},"$engine":{"command":"rm"}}`)
+
+	buf := &bytes.Buffer{}
+	m, err := Export(buf, src, []*easportswrc.Stage{stage, easportswrc.GetStageByID(1, 2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Stages) != 1 {
+		t.Fatalf("stages = %+v, want only the stage with files", m.Stages)
+	}
+	if got := m.Stages[0].Files; !slices.Equal(got, []string{"pacenote.log"}) {
+		t.Errorf("files = %q", got)
+	}
+	if m.Stages[0].Dictionary != "mine" {
+		t.Errorf("stage dictionary = %q, want mine", m.Stages[0].Dictionary)
+	}
+	if _, ok := m.Dictionary["crest"]; !ok || len(m.Dictionary) != 1 {
+		t.Errorf("dictionary = %v, want only used words", m.Dictionary)
+	}
+	if _, ok := m.Dictionaries["mine"][engineKey]; ok {
+		t.Errorf("%s was exported", engineKey)
+	}
+	if _, ok := m.Dictionaries["mine"]["3-left"]; !ok {
+		t.Errorf("named dictionary = %v", m.Dictionaries)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := t.TempDir()
+	report, err := Import(zr, dst, ConflictKeep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Imported) != 2 || len(report.Skipped) != 0 || report.Dictionary != 2 {
+		t.Errorf("report = %+v", report)
+	}
+	// モードは読み込まず、名前付き辞書の名前だけを設定する
+	if b, _ := os.ReadFile(filepath.Join(dst, stage.Dir(), "settings.json")); !strings.Contains(string(b), `"mine"`) || strings.Contains(string(b), "mode") {
+		t.Errorf("settings.json = %s", b)
+	}
+	b, err := os.ReadFile(filepath.Join(dst, stage.Dir(), "pacenote.log"))
+	if err != nil || string(b) != "1.0,2.0,3.0,3-left into crest\n4.0,5.0,6.0,$engine\n" {
+		t.Errorf("pacenote.log = %q, %v", b, err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, stage.Dir(), "telemetry.log")); !os.IsNotExist(err) {
+		t.Errorf("telemetry.log was imported")
+	}
+	if dict := readDictionary(t, filepath.Join(dst, "dictionaries", "mine.json")); len(dict) != 1 {
+		t.Errorf("mine.json = %v", dict)
+	}
+}
+
+func TestImportValidation(t *testing.T) {
+	stage := easportswrc.GetStageByID(1, 1)
+	entry := StageEntry{Location: 1, Stage: 1, LocationName: stage.Location, StageName: stage.Stage, Files: []string{"pacenote.log", "../evil.txt"}}
+	m := &Manifest{
+		Version: Version,
+		Stages: []StageEntry{
+			entry,
+			{Location: 99, Stage: 1, Files: []string{"pacenote.log"}},
+			{Location: 1, Stage: 2, LocationName: stage.Location, StageName: "wrong", Files: []string{"pacenote.log"}},
+		},
+		Dictionary: map[string]json.RawMessage{
+			"crest":   json.RawMessage(`{"text":"クレスト"}`),
+			engineKey: json.RawMessage(`{"command":"rm"}`),
+		},
+		Dictionaries: map[string]map[string]json.RawMessage{
+			"../evil": {"crest": json.RawMessage(`{}`)},
+			"mine":    {engineKey: json.RawMessage(`{"command":"rm"}`), "crest": json.RawMessage(`{}`)},
+		},
+	}
+	zr := packZip(t, m, map[string]string{
+		entry.dir() + "/pacenote.log": "1.0,2.0,3.0,crest\n",
+		entry.dir() + "/../evil.txt":  "evil",
+		"stages/1/2/pacenote.log":     "1.0,2.0,3.0,crest\n",
+	})
+	dst := t.TempDir()
+	report, err := Import(zr, dst, ConflictKeep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(stage.Dir(), "pacenote.log")}; !slices.Equal(report.Imported, want) {
+		t.Errorf("imported = %q, want %q", report.Imported, want)
+	}
+	// 不明なファイル、カタログに無いステージ、名前の違うステージ、不正な辞書名、$engine
+	if len(report.Skipped) != 5 {
+		t.Errorf("skipped = %q", report.Skipped)
+	}
+	if _, err := os.Stat(filepath.Join(dst, easportswrc.GetStageByID(1, 2).Dir())); !os.IsNotExist(err) {
+		t.Error("mismatched stage was imported")
+	}
+	if _, ok := readDictionary(t, filepath.Join(dst, "dictionary.json"))[engineKey]; ok {
+		t.Errorf("%s was imported into dictionary.json", engineKey)
+	}
+	if dict := readDictionary(t, filepath.Join(dst, "dictionaries", "mine.json")); len(dict) != 1 {
+		t.Errorf("mine.json = %v", dict)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "evil.json")); !os.IsNotExist(err) {
+		t.Error("invalid dictionary name was imported")
+	}
+
+	m.Version = Version + 1
+	if _, err := Import(packZip(t, m, map[string]string{}), dst, ConflictKeep); err == nil {
+		t.Error("newer pack version was accepted")
+	}
+	if _, err := Import(zr, dst, "merge"); err == nil {
+		t.Error("invalid conflict policy was accepted")
+	}
+}
+
+func TestImportConflict(t *testing.T) {
+	stage := easportswrc.GetStageByID(1, 1)
+	entry := StageEntry{Location: 1, Stage: 1, LocationName: stage.Location, StageName: stage.Stage, Files: []string{"pacenote.log"}}
+	m := &Manifest{
+		Version:    Version,
+		Stages:     []StageEntry{entry},
+		Dictionary: map[string]json.RawMessage{"crest": json.RawMessage(`{"text":"new"}`)},
+	}
+	for _, tt := range []struct {
+		conflict Conflict
+		pacenote string
+		word     string
+	}{
+		{ConflictKeep, "old\n", `{"text":"old"}`},
+		{ConflictReplace, "new\n", `{"text":"new"}`},
+	} {
+		t.Run(string(tt.conflict), func(t *testing.T) {
+			dst := t.TempDir()
+			fpath := filepath.Join(dst, stage.Dir(), "pacenote.log")
+			writeFile(t, fpath, "old\n")
+			writeFile(t, filepath.Join(dst, "dictionary.json"), `{"crest":{"text":"old"}}`)
+			zr := packZip(t, m, map[string]string{entry.dir() + "/pacenote.log": "new\n"})
+			if _, err := Import(zr, dst, tt.conflict); err != nil {
+				t.Fatal(err)
+			}
+			if b, _ := os.ReadFile(fpath); string(b) != tt.pacenote {
+				t.Errorf("pacenote.log = %q, want %q", b, tt.pacenote)
+			}
+			var word bytes.Buffer
+			json.Compact(&word, readDictionary(t, filepath.Join(dst, "dictionary.json"))["crest"])
+			if word.String() != tt.word {
+				t.Errorf("crest = %s, want %s", word.String(), tt.word)
+			}
+		})
+	}
+}
+
+func TestImportSettings(t *testing.T) {
+	stage := easportswrc.GetStageByID(1, 1)
+	entry := StageEntry{Location: 1, Stage: 1, LocationName: stage.Location, StageName: stage.Stage, Files: []string{"pacenote.log", settingsFile}}
+	m := &Manifest{Version: Version, Stages: []StageEntry{entry}}
+	files := func() map[string]string {
+		return map[string]string{
+			entry.dir() + "/pacenote.log": "1.0,2.0,3.0,crest\n",
+			// 以前のパックに含まれていた settings.json
+			entry.dir() + "/" + settingsFile: `{"mode":"record","dictionary":"theirs"}`,
+		}
+	}
+	for _, tt := range []struct {
+		name     string
+		local    string // 読み込み前の settings.json(空なら無し)
+		conflict Conflict
+		want     string
+		skipped  int
+	}{
+		{"no local settings", "", ConflictKeep, `{"dictionary":"theirs"}`, 0},
+		{"local mode only", `{"mode":"play"}`, ConflictReplace, `{"dictionary":"theirs","mode":"play"}`, 0},
+		{"local dictionary", `{"mode":"play","dictionary":"mine"}`, ConflictReplace, `{"mode":"play","dictionary":"mine"}`, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			fpath := filepath.Join(dst, stage.Dir(), settingsFile)
+			if tt.local != "" {
+				writeFile(t, fpath, tt.local)
+			}
+			report, err := Import(packZip(t, m, files()), dst, tt.conflict)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got bytes.Buffer
+			b, _ := os.ReadFile(fpath)
+			json.Compact(&got, b)
+			if got.String() != tt.want {
+				t.Errorf("settings.json = %s, want %s", got.String(), tt.want)
+			}
+			if len(report.Skipped) != tt.skipped {
+				t.Errorf("skipped = %q", report.Skipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"archive/zip"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/nobonobo/wrc-pacenote-mod/config"
+	"github.com/nobonobo/wrc-pacenote-mod/easportswrc"
+	"github.com/nobonobo/wrc-pacenote-mod/pack"
+)
+
+// exportCommand は wrc-pacenote-mod export [-o pack.zip] ロケーション番号[/ステージ番号]...
+func exportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	config.RegisterFlags(fs)
+	output := fs.String("o", fmt.Sprintf("pacenotes-%s.zip", time.Now().Format("20060102")), "output pack file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: wrc-pacenote-mod export [options] location[/stage]...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no stage specified")
+	}
+	stages := []*easportswrc.Stage{}
+	for _, spec := range fs.Args() {
+		list, err := pack.Select(spec)
+		if err != nil {
+			return err
+		}
+		stages = append(stages, list...)
+	}
+	fp, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	m, err := pack.Export(fp, config.Config.LogDir, stages)
+	if err != nil {
+		os.Remove(*output)
+		return err
+	}
+	for _, s := range m.Stages {
+		log.Printf("exported: %02d.%s/%02d.%s %v", s.Location, s.LocationName, s.Stage, s.StageName, s.Files)
+	}
+	log.Printf("pack saved: %q (%d stages, %d words)", *output, len(m.Stages), len(m.Dictionary))
+	return fp.Close()
+}
+
+// importCommand は wrc-pacenote-mod import [-conflict keep|replace|new] pack.zip...
+func importCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	config.RegisterFlags(fs)
+	conflict := fs.String("conflict", string(pack.ConflictKeep), "existing files and dictionary words: keep, replace or new (save the files as a new take)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: wrc-pacenote-mod import [options] pack.zip...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no pack specified")
+	}
+	for _, fpath := range fs.Args() {
+		zr, err := zip.OpenReader(fpath)
+		if err != nil {
+			return err
+		}
+		report, err := pack.Import(&zr.Reader, config.Config.LogDir, pack.Conflict(*conflict))
+		zr.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", fpath, err)
+		}
+		for _, v := range report.Imported {
+			log.Printf("imported: %s", v)
+		}
+		for _, v := range report.Skipped {
+			log.Printf("skipped: %s", v)
+		}
+		log.Printf("dictionary: %d words updated", report.Dictionary)
+	}
+	return nil
+}
+
+// subcommand は export/import サブコマンドを実行する。サブコマンドでなければ false を返す。
+func subcommand() bool {
+	if len(os.Args) < 2 {
+		return false
+	}
+	var run func([]string) error
+	switch os.Args[1] {
+	case "export":
+		run = exportCommand
+	case "import":
+		run = importCommand
+	default:
+		return false
+	}
+	if err := config.Load(); err != nil {
+		log.Fatal(err)
+	}
+	if err := run(os.Args[2:]); err != nil {
+		log.Fatal(err)
+	}
+	return true
+}
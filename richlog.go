@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"flag"
+	"strconv"
+	"time"
+
+	"github.com/nobonobo/wrc-pacenote-mod/api"
+	"github.com/nobonobo/wrc-pacenote-mod/easportswrc"
+)
+
+// richLogRate は telemetry.csv.gz に記録する毎秒のパケット数(0なら記録しない)
+var richLogRate = float64(0)
+
+func init() {
+	flag.Float64Var(&richLogRate, "rich-log-rate", richLogRate, "packets per second saved to telemetry.csv.gz with all fields (0: disabled, <0: every packet)")
+}
+
+// richLog はパケット全体を gzip 圧縮した CSV に記録する
+type richLog struct {
+	buf  bytes.Buffer
+	gz   *gzip.Writer
+	w    *csv.Writer
+	last float32
+}
+
+func newRichLog() *richLog {
+	if richLogRate == 0 {
+		return nil
+	}
+	l := &richLog{last: -1}
+	l.gz = gzip.NewWriter(&l.buf)
+	l.w = csv.NewWriter(l.gz)
+	l.w.Write(api.RichHeader())
+	return l
+}
+
+// Packet はレートに合わせて間引いたパケットを書き出す
+func (l *richLog) Packet(audio time.Duration, pkt *easportswrc.PacketEASportsWRC) {
+	if l == nil {
+		return
+	}
+	if richLogRate > 0 && l.last >= 0 && float64(pkt.GameTotalTime-l.last) < 1/richLogRate {
+		return
+	}
+	l.last = pkt.GameTotalTime
+	l.w.Write(append([]string{strconv.FormatInt(int64(audio), 10)}, pkt.Values()...))
+}
+
+// Bytes は書き込みを終えて圧縮済みの内容を返す
+func (l *richLog) Bytes() ([]byte, error) {
+	l.w.Flush()
+	if err := l.w.Error(); err != nil {
+		return nil, err
+	}
+	if err := l.gz.Close(); err != nil {
+		return nil, err
+	}
+	return l.buf.Bytes(), nil
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nobonobo/wrc-pacenote-mod/api"
+	"github.com/nobonobo/wrc-pacenote-mod/capture"
+	"github.com/nobonobo/wrc-pacenote-mod/easportswrc"
+)
+
+type sessionState int
+
+const (
+	stateIdle      sessionState = iota // 記録なし
+	stateArmed                         // スタート地点で待機中(キャプチャ開始済み)
+	stateRecording                     // 走行中
+	stateFinished                      // 完走して保存済み
+	stateAborted                       // リスタートやタイムアウトで破棄
+)
+
+func (s sessionState) String() string {
+	switch s {
+	case stateIdle:
+		return "idle"
+	case stateArmed:
+		return "armed"
+	case stateRecording:
+		return "recording"
+	case stateFinished:
+		return "finished"
+	case stateAborted:
+		return "aborted"
+	}
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
+func (s sessionState) active() bool {
+	return s == stateArmed || s == stateRecording
+}
+
+// take は1回分の記録
+type take struct {
+	dir       string
+	telemetry *bytes.Buffer
+	rich      *richLog      // -rich-log-rate 指定時のみ
+	fired     *bytes.Buffer // 再生しながら記録するモードのみ。playback.log の内容。
+	format    *capture.WavFormat
+	pcm       bytes.Buffer
+	liveLen   int // 最後に走行中のパケットを受けた時点の音声バイト数
+	cancel    context.CancelFunc
+	meta      api.Take
+}
+
+// current は記録済み音声の長さ(ポーズ区間は除かれる)
+func (t *take) current() time.Duration {
+	if t.format == nil {
+		return 0
+	}
+	bytesPerSec := int(t.format.SamplesPerSec) * int(t.format.Channels) * int(t.format.BitsPerSample/8)
+	return time.Duration(float64(t.pcm.Len()) / float64(bytesPerSec) * float64(time.Second))
+}
+
+// takeSuffix は記録ファイル群がどれも存在しない連番サフィックスを返す。
+// 無印の pacenote.log/regions.log は無印の記録から作るので空きの判定に含めないが、
+// 連番のものはパックから別テイクとして読み込んだものなので使用中とみなす。
+func takeSuffix(dir string) string {
+	for idx := 0; ; idx++ {
+		suffix := api.TakeSuffix(idx)
+		exists := false
+		names := []string{"telemetry.log", "telemetry.csv.gz", "capture.wav", "take.json", "playback.log"}
+		if idx > 0 {
+			names = api.TakeFiles
+		}
+		for _, name := range names {
+			if _, err := os.Stat(filepath.Join(dir, name+suffix)); err == nil {
+				exists = true
+			}
+		}
+		if !exists {
+			return suffix
+		}
+	}
+}
+
+// save は記録を保存して結果を session イベントで通知する。
+// telemetry.log と take.json が保存できれば音声がなくても保存済みとする。
+func (t *take) save() {
+	suffix := takeSuffix(t.dir)
+	logName := filepath.Join(t.dir, "telemetry.log"+suffix)
+	if err := os.WriteFile(logName, t.telemetry.Bytes(), 0o644); err != nil {
+		t.fail(err)
+		return
+	}
+	log.Printf("log saved: %q", logName)
+	metaName := filepath.Join(t.dir, "take.json"+suffix)
+	if err := api.SaveTake(metaName, &t.meta); err != nil {
+		t.fail(err)
+		return
+	}
+	if t.rich != nil {
+		richName := filepath.Join(t.dir, "telemetry.csv.gz"+suffix)
+		if b, err := t.rich.Bytes(); err != nil {
+			t.fail(err)
+		} else if err := os.WriteFile(richName, b, 0o644); err != nil {
+			t.fail(err)
+		} else {
+			log.Printf("rich log saved: %q", richName)
+		}
+	}
+	switch {
+	case t.fired != nil:
+		// 再生しながら記録した走行の音声は無音なので保存しない
+		playbackName := filepath.Join(t.dir, "playback.log"+suffix)
+		if err := os.WriteFile(playbackName, t.fired.Bytes(), 0o644); err != nil {
+			t.fail(err)
+		} else {
+			log.Printf("playback log saved: %q", playbackName)
+		}
+	case t.format == nil:
+		log.Println("wav save skipped: no audio")
+	default:
+		if err := t.saveWav(filepath.Join(t.dir, "capture.wav"+suffix)); err != nil {
+			t.fail(err)
+		}
+	}
+	ev := api.SessionEvent{State: "saved", Dir: t.dir}
+	if t.meta.Finish != nil {
+		ev.Reason = t.meta.Finish.Reason
+	}
+	api.Publish("session", ev)
+}
+
+// fail は保存の失敗を error イベントで通知する
+func (t *take) fail(err error) {
+	log.Println(err)
+	api.Publish("error", api.ErrorEvent{Source: "save", Message: err.Error()})
+}
+
+func (t *take) saveWav(wavName string) error {
+	b, err := t.format.Marshal(t.pcm.Bytes())
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(wavName, b, 0o644); err != nil {
+		return err
+	}
+	log.Printf("wav saved: %q", wavName)
+	return nil
+}
+
+// session はパケットとキャプチャのイベントから記録の開始・完走・破棄を決める
+type session struct {
+	mu       sync.Mutex
+	state    sessionState
+	take     *take
+	last     *easportswrc.PacketEASportsWRC
+	lastSeen time.Time // 最後に走行中のパケットを受けた時刻
+	paused   bool
+	finish   *finishDetector
+	pauseGap time.Duration
+	timeout  time.Duration
+	playback bool // 発火したペースノートも記録する
+
+	logDir  func(stageLength float64) string
+	capture func(ctx context.Context, output func(capture.Chunk)) error
+	save    func(t *take)
+	saved   func(dir string) // 保存後に呼ぶ(nil なら呼ばない)
+	speech  func(text string)
+}
+
+func newSession(speechCh chan<- string, saved func(dir string)) *session {
+	return &session{
+		pauseGap: 500 * time.Millisecond,
+		timeout:  10 * time.Minute,
+		finish:   newFinishDetector(finishMethods, finishMargin),
+		logDir:   getLogDir,
+		capture:  audioCapture(),
+		save:     (*take).save,
+		saved:    saved,
+		speech: func(text string) {
+			speechCh <- text
+		},
+	}
+}
+
+// newPlaybackSession は再生しながら記録するモードの session を作る。音声は記録しない。
+func newPlaybackSession(speechCh chan<- string, saved func(dir string)) *session {
+	s := newSession(speechCh, saved)
+	s.capture = silentCapture
+	s.playback = true
+	return s
+}
+
+func (s *session) setState(next sessionState) {
+	if s.state == next {
+		return
+	}
+	log.Printf("session: %s -> %s", s.state, next)
+	s.state = next
+	ev := api.SessionEvent{State: next.String()}
+	if s.take != nil {
+		ev.Dir = s.take.dir
+	}
+	api.Publish("session", ev)
+}
+
+func (s *session) arm(ctx context.Context, pkt *easportswrc.PacketEASportsWRC) {
+	log.Printf("packet: %v", pkt)
+	dir := s.logDir(pkt.StageLength)
+	os.MkdirAll(dir, 0755)
+	log.Printf("logger (re)start: %q", dir)
+	ctx, cancel := context.WithCancel(ctx)
+	t := &take{
+		dir:       dir,
+		telemetry: bytes.NewBuffer(nil),
+		rich:      newRichLog(),
+		cancel:    cancel,
+	}
+	if s.playback {
+		t.fired = bytes.NewBuffer(nil)
+	}
+	s.take = t
+	s.paused = false
+	s.finish.Reset()
+	s.setState(stateArmed)
+	go func() {
+		log.Println("audio recorder: started")
+		defer log.Println("audio recorder: terminated")
+		for range 3 {
+			if err := s.capture(ctx, func(c capture.Chunk) { s.Chunk(t, c) }); err != nil {
+				log.Println(err)
+				api.Publish("error", api.ErrorEvent{Source: "capture", Message: err.Error()})
+				time.Sleep(500 * time.Millisecond)
+				continue
+			}
+			return
+		}
+		s.captureFailed(t)
+	}()
+}
+
+// close は現在のテイクを切り離して破棄する
+func (s *session) close(next sessionState) {
+	s.setState(next)
+	t := s.take
+	s.take = nil
+	s.paused = false
+	if t == nil {
+		return
+	}
+	t.cancel()
+	log.Print("log save skipped")
+}
+
+// complete は現在のテイクを完走として保存する
+func (s *session) complete(reason string) {
+	s.setState(stateFinished)
+	t := s.take
+	s.take = nil
+	s.paused = false
+	if t == nil {
+		return
+	}
+	t.cancel()
+	pkt := s.last
+	log.Printf("finish detected: %s", reason)
+	log.Printf("packet: %v", pkt)
+	t.meta.Finish = &api.Finish{
+		Reason:      reason,
+		Distance:    pkt.StageCurrentDistance,
+		StageLength: pkt.StageLength,
+		StageTime:   float64(pkt.StageCurrentTime),
+	}
+	go func() {
+		s.save(t)
+		if s.saved != nil {
+			s.saved(t.dir)
+		}
+	}()
+}
+
+// Packet はテレメトリパケット受信毎に呼ばれる
+func (s *session) Packet(ctx context.Context, now time.Time, pkt *easportswrc.PacketEASportsWRC) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last := s.last
+	s.last = pkt
+	// ポーズ中はフレームも位置も進まないパケットが届くことがある
+	if last != nil && last.GameFrameCount == pkt.GameFrameCount && !isChange(last, pkt) {
+		return
+	}
+	if s.paused {
+		log.Printf("session: resumed after %v", now.Sub(s.lastSeen))
+		s.paused = false
+	}
+	s.lastSeen = now
+	start := (last == nil || last.StageCurrentDistance != 0) && pkt.StageCurrentDistance == 0
+	stageChanged := last != nil && last.StageLength != pkt.StageLength
+	switch s.state {
+	case stateIdle, stateFinished, stateAborted:
+		if start {
+			s.arm(ctx, pkt)
+		}
+	case stateArmed, stateRecording:
+		switch {
+		case stageChanged:
+			s.close(stateAborted)
+			if start {
+				s.arm(ctx, pkt)
+			}
+		case start:
+			s.close(stateAborted)
+			s.arm(ctx, pkt)
+		case s.state == stateArmed && pkt.StageCurrentDistance > 0:
+			s.setState(stateRecording)
+		}
+	}
+	if s.state.active() {
+		s.take.liveLen = s.take.pcm.Len()
+	}
+	if s.state.active() && isChange(last, pkt) {
+		fmt.Fprintf(s.take.telemetry, "%d,%d,%f,%f,%f,%f,%f\n",
+			pkt.PacketUid,
+			s.take.current(),
+			pkt.VehiclePositionX,
+			pkt.VehiclePositionY,
+			pkt.VehiclePositionZ,
+			pkt.GameTotalTime,
+			pkt.StageCurrentTime,
+		)
+		s.take.rich.Packet(s.take.current(), pkt)
+	}
+	if s.state == stateRecording {
+		if reason, ok := s.finish.Packet(pkt); ok {
+			s.complete(reason)
+		}
+	}
+}
+
+// Fire は発火したペースノートとその時点の車両状態を記録中のテイクに加える
+func (s *session) Fire(p *Pacenote, pkt *easportswrc.PacketEASportsWRC) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.state.active() || s.take.fired == nil {
+		return
+	}
+	fmt.Fprintf(s.take.fired, "%d,%f,%f,%f,%f,%f,%f,%f,%f,%f,%s\n",
+		p.Index,
+		pkt.VehiclePositionX,
+		pkt.VehiclePositionY,
+		pkt.VehiclePositionZ,
+		pkt.VehicleVelocityX,
+		pkt.VehicleVelocityY,
+		pkt.VehicleVelocityZ,
+		pkt.VehicleSpeed,
+		pkt.StageCurrentDistance,
+		pkt.StageCurrentTime,
+		p.Message,
+	)
+}
+
+// Chunk はキャプチャした音声チャンク毎に呼ばれる
+func (s *session) Chunk(t *take, c capture.Chunk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.take != t || s.paused {
+		return
+	}
+	if t.format == nil {
+		t.format = c.Format
+	}
+	t.pcm.Write(c.Buffer)
+}
+
+// captureFailed はキャプチャが続けて失敗したテイクを破棄して知らせる。
+// 読み上げは詰まることがあるのでロックを外してから行う。
+func (s *session) captureFailed(t *take) {
+	s.mu.Lock()
+	if s.take != t {
+		s.mu.Unlock()
+		return
+	}
+	s.close(stateAborted)
+	s.mu.Unlock()
+	api.Publish("error", api.ErrorEvent{Source: "capture", Message: "capture failed"})
+	s.speech("キャプチャーに失敗しました")
+}
+
+// Abort は記録中のテイクを破棄する
+func (s *session) Abort() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state.active() {
+		s.close(stateAborted)
+	}
+}
+
+// pause は記録を一時停止し、最後の走行中パケット以降の音声を切り捨てる
+func (s *session) pause() {
+	t := s.take
+	cut := t.pcm.Len() - t.liveLen
+	t.pcm.Truncate(t.liveLen)
+	s.paused = true
+	log.Printf("session: paused (%d bytes of audio cut)", cut)
+}
+
+// Tick は定期的に呼ばれ、パケットが途絶えた記録を完走・一時停止・破棄とする
+func (s *session) Tick(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.state.active() {
+		return
+	}
+	gap := now.Sub(s.lastSeen)
+	if !s.paused && gap > s.pauseGap {
+		if reason, ok := s.finish.Timeout(); ok && s.state == stateRecording {
+			s.complete(reason)
+			return
+		}
+		s.pause()
+	}
+	if s.paused && gap > s.timeout {
+		log.Println("session: packet timeout")
+		s.close(stateAborted)
+	}
+}
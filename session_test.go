@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nobonobo/wrc-pacenote-mod/api"
+	"github.com/nobonobo/wrc-pacenote-mod/capture"
+	"github.com/nobonobo/wrc-pacenote-mod/easportswrc"
+)
+
+// step はテスト用の入力。at はテスト開始からの経過時間。
+type step struct {
+	at       time.Duration
+	tick     bool    // true ならパケットの代わりに Tick を呼ぶ
+	distance float64 // StageCurrentDistance
+	length   float64 // StageLength
+}
+
+func packetStep(at time.Duration, distance, length float64) step {
+	return step{at: at, distance: distance, length: length}
+}
+
+func tickStep(at time.Duration) step {
+	return step{at: at, tick: true}
+}
+
+// testSession はファイルや音声デバイスを使わない session を作る
+func testSession(t *testing.T, captureErr error) (*session, chan *take, chan string) {
+	saved := make(chan *take, 4)
+	speech := make(chan string, 4)
+	s := &session{
+		pauseGap: 500 * time.Millisecond,
+		timeout:  10 * time.Second,
+		finish:   newFinishDetector("distance,stream", 100),
+		logDir: func(stageLength float64) string {
+			return t.TempDir()
+		},
+		capture: func(ctx context.Context, output func(capture.Chunk)) error {
+			if captureErr != nil {
+				return captureErr
+			}
+			<-ctx.Done()
+			return nil
+		},
+		save: func(t *take) {
+			saved <- t
+		},
+		speech: func(text string) {
+			speech <- text
+		},
+	}
+	return s, saved, speech
+}
+
+func TestSessionTransitions(t *testing.T) {
+	const length = 1000
+	tests := []struct {
+		name   string
+		steps  []step
+		state  sessionState
+		saved  bool
+		reason string
+	}{
+		{
+			name:  "arm at the start line",
+			steps: []step{packetStep(0, 0, length)},
+			state: stateArmed,
+		},
+		{
+			name:  "record after the start",
+			steps: []step{packetStep(0, 0, length), packetStep(100*time.Millisecond, 10, length)},
+			state: stateRecording,
+		},
+		{
+			name: "finish by distance",
+			steps: []step{
+				packetStep(0, 0, length),
+				packetStep(100*time.Millisecond, 10, length),
+				packetStep(200*time.Millisecond, length, length),
+			},
+			state:  stateFinished,
+			saved:  true,
+			reason: finishDistance,
+		},
+		{
+			name: "finish when the stream stops near the end",
+			steps: []step{
+				packetStep(0, 0, length),
+				packetStep(100*time.Millisecond, 950, length),
+				tickStep(time.Second),
+			},
+			state:  stateFinished,
+			saved:  true,
+			reason: finishStream,
+		},
+		{
+			name: "restart aborts and arms again",
+			steps: []step{
+				packetStep(0, 0, length),
+				packetStep(100*time.Millisecond, 500, length),
+				packetStep(200*time.Millisecond, 0, length),
+			},
+			state: stateArmed,
+		},
+		{
+			name: "stage change aborts",
+			steps: []step{
+				packetStep(0, 0, length),
+				packetStep(100*time.Millisecond, 500, length),
+				packetStep(200*time.Millisecond, 510, 2000),
+			},
+			state: stateAborted,
+		},
+		{
+			name: "pause keeps recording",
+			steps: []step{
+				packetStep(0, 0, length),
+				packetStep(100*time.Millisecond, 500, length),
+				tickStep(time.Second),
+				packetStep(5*time.Second, 510, length),
+			},
+			state: stateRecording,
+		},
+		{
+			name: "long pause aborts",
+			steps: []step{
+				packetStep(0, 0, length),
+				packetStep(100*time.Millisecond, 500, length),
+				tickStep(time.Second),
+				tickStep(20 * time.Second),
+			},
+			state: stateAborted,
+		},
+		{
+			name: "finish is ignored before the start",
+			steps: []step{
+				packetStep(0, 500, length),
+				packetStep(100*time.Millisecond, length, length),
+			},
+			state: stateIdle,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, saved, _ := testSession(t, nil)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			base := time.Now()
+			for i, st := range tt.steps {
+				now := base.Add(st.at)
+				if st.tick {
+					s.Tick(now)
+					continue
+				}
+				s.Packet(ctx, now, &easportswrc.PacketEASportsWRC{
+					GameFrameCount:       uint64(i + 1),
+					StageCurrentDistance: st.distance,
+					StageLength:          st.length,
+					VehiclePositionX:     float32(st.distance),
+				})
+			}
+			s.mu.Lock()
+			state := s.state
+			s.mu.Unlock()
+			if state != tt.state {
+				t.Errorf("state = %s, want %s", state, tt.state)
+			}
+			select {
+			case take := <-saved:
+				if !tt.saved {
+					t.Fatalf("unexpected save: %q", take.dir)
+				}
+				if take.meta.Finish == nil || take.meta.Finish.Reason != tt.reason {
+					t.Errorf("finish = %+v, want reason %q", take.meta.Finish, tt.reason)
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tt.saved {
+					t.Fatal("take was not saved")
+				}
+			}
+		})
+	}
+}
+
+func TestSessionCaptureFailure(t *testing.T) {
+	s, saved, _ := testSession(t, errors.New("no device"))
+	// 読み上げが詰まっていても session のロックは外れていること
+	speech := make(chan string)
+	s.speech = func(text string) {
+		speech <- text
+	}
+	s.Packet(context.Background(), time.Now(), &easportswrc.PacketEASportsWRC{GameFrameCount: 1, StageLength: 1000})
+	aborted := make(chan struct{})
+	go func() {
+		for {
+			s.mu.Lock()
+			state := s.state
+			s.mu.Unlock()
+			if state == stateAborted {
+				close(aborted)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session was not aborted or stayed locked")
+	}
+	select {
+	case <-speech:
+	case <-time.After(time.Second):
+		t.Fatal("capture failure was not announced")
+	}
+	select {
+	case <-saved:
+		t.Error("aborted take was saved")
+	default:
+	}
+}
+
+func TestSessionPlayback(t *testing.T) {
+	s, saved, _ := testSession(t, nil)
+	s.playback = true
+	reloaded := make(chan string, 1)
+	s.saved = func(dir string) {
+		reloaded <- dir
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	note := func(i int) *Pacenote {
+		return &Pacenote{Pacenote: api.Pacenote{Message: "3-left"}, Index: i}
+	}
+	now := time.Now()
+	s.Fire(note(0), &easportswrc.PacketEASportsWRC{}) // 記録前は無視する
+	for i, distance := range []float64{0, 10, 500, 1000} {
+		pkt := &easportswrc.PacketEASportsWRC{GameFrameCount: uint64(i + 1), StageCurrentDistance: distance, StageLength: 1000}
+		s.Packet(ctx, now.Add(time.Duration(i)*100*time.Millisecond), pkt)
+		if distance == 500 {
+			s.Fire(note(1), pkt)
+		}
+	}
+	select {
+	case take := <-saved:
+		if take.fired == nil {
+			t.Fatal("playback log was not recorded")
+		}
+		want := "1,0.000000,0.000000,0.000000,0.000000,0.000000,0.000000,0.000000,500.000000,0.000000,3-left\n"
+		if got := take.fired.String(); got != want {
+			t.Errorf("playback log = %q, want %q", got, want)
+		}
+		select {
+		case dir := <-reloaded:
+			if dir != take.dir {
+				t.Errorf("saved dir = %q, want %q", dir, take.dir)
+			}
+		case <-time.After(time.Second):
+			t.Error("saved was not called")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("take was not saved")
+	}
+}
+
+var setupOnce sync.Once
+
+// subscribeEvents は /api/events に接続して受け取ったイベントを返す
+func subscribeEvents(t *testing.T) <-chan api.Event {
+	setupOnce.Do(func() {
+		api.Setup(context.Background(), nil)
+	})
+	srv := httptest.NewServer(http.DefaultServeMux)
+	t.Cleanup(srv.Close)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, err := http.NewRequestWithContext(ctx, "GET", srv.URL+"/api/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	ch := make(chan api.Event, 16)
+	scanner := bufio.NewScanner(resp.Body)
+	// 接続直後の mode イベントを受けてから購読済みとする
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "data: ") {
+			break
+		}
+	}
+	go func() {
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var ev api.Event
+			if err := json.Unmarshal([]byte(data), &ev); err == nil {
+				ch <- ev
+			}
+		}
+	}()
+	return ch
+}
+
+func TestTakeSave(t *testing.T) {
+	tests := []struct {
+		name  string
+		dir   string
+		event string
+		files []string
+	}{
+		{"saved without audio", t.TempDir(), "session", []string{"telemetry.log", "take.json"}},
+		{"write failure", filepath.Join(t.TempDir(), "missing"), "error", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := subscribeEvents(t)
+			tk := &take{
+				dir:       tt.dir,
+				telemetry: bytes.NewBufferString("telemetry\n"),
+				meta:      api.Take{Finish: &api.Finish{Reason: finishDistance}},
+			}
+			tk.save()
+			select {
+			case ev := <-events:
+				if ev.Type != tt.event {
+					t.Fatalf("event = %s %v, want %s", ev.Type, ev.Data, tt.event)
+				}
+				data, _ := ev.Data.(map[string]any)
+				switch ev.Type {
+				case "session":
+					if data["state"] != "saved" || data["reason"] != finishDistance {
+						t.Errorf("session event = %v", data)
+					}
+				case "error":
+					if data["source"] != "save" {
+						t.Errorf("error event = %v", data)
+					}
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no event")
+			}
+			for _, name := range tt.files {
+				if _, err := os.Stat(filepath.Join(tt.dir, name)); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func TestTakeSuffix(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  string
+	}{
+		{"empty stage", nil, ""},
+		{"pacenotes of the first take", []string{"pacenote.log", "regions.log"}, ""},
+		{"recorded take", []string{"telemetry.log", "pacenote.log"}, ".1"},
+		{"imported take", []string{"telemetry.log", "pacenote.log.1"}, ".2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, name := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := takeSuffix(dir); got != tt.want {
+				t.Errorf("takeSuffix = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+//go:build !headless
+
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/ebitengine/oto/v3"
+	"github.com/nobonobo/wrc-pacenote-mod/api"
+	"github.com/nobonobo/wrc-pacenote-mod/ttsengine"
+)
+
+// audioSupported は音声デバイスへの出力を含めてビルドしたかどうか
+const audioSupported = true
+
+type speaker func(ctx context.Context, engine *ttsengine.Engine, speechCh <-chan string)
+
+// newSpeaker は音声デバイスを開き、文言を読み上げ続ける関数を返す
+func newSpeaker() (speaker, error) {
+	ctxOto, _, err := oto.NewContext(&oto.NewContextOptions{
+		SampleRate:   48000,
+		ChannelCount: 1,
+		Format:       oto.FormatSignedInt16LE,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, engine *ttsengine.Engine, speechCh <-chan string) {
+		for {
+			if err := engine.Start(ctx, ctxOto, speechCh); err != nil {
+				log.Print(err)
+				api.Publish("error", api.ErrorEvent{Source: "tts", Message: err.Error()})
+			}
+			select {
+			default:
+				continue
+			case <-ctx.Done():
+			}
+			break
+		}
+	}, nil
+}
@@ -0,0 +1,19 @@
+//go:build headless
+
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/nobonobo/wrc-pacenote-mod/ttsengine"
+)
+
+// audioSupported は音声デバイスへの出力を含めてビルドしたかどうか
+const audioSupported = false
+
+type speaker func(ctx context.Context, engine *ttsengine.Engine, speechCh <-chan string)
+
+func newSpeaker() (speaker, error) {
+	return nil, errors.New("built without audio output: run with -headless")
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nobonobo/wrc-pacenote-mod/api"
+	"github.com/nobonobo/wrc-pacenote-mod/easportswrc"
+)
+
+var (
+	splitPoints    = "25%,50%,75%"
+	announceFinish = false
+)
+
+func init() {
+	flag.StringVar(&splitPoints, "splits", splitPoints, "comma separated split checkpoints in meters or percent of the stage length (e.g. 2000,4000 or 25%,50%)")
+	flag.BoolVar(&announceFinish, "announce-finish", announceFinish, "speak the stage time difference against the personal best at the finish")
+}
+
+// parseSplits はチェックポイント指定をステージ長に対する距離(m)の昇順にする
+func parseSplits(spec string, stageLength float64) ([]float64, error) {
+	res := []float64{}
+	for _, v := range strings.Split(spec, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		percent := strings.HasSuffix(v, "%")
+		f, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid split: %q", v)
+		}
+		if percent {
+			f = stageLength * f / 100
+		}
+		if f > 0 && f < stageLength {
+			res = append(res, f)
+		}
+	}
+	sort.Float64s(res)
+	return res, nil
+}
+
+// stageTimer はモードによらず走行のスプリットと完走タイムを記録する
+type stageTimer struct {
+	mu       sync.Mutex
+	finish   *finishDetector
+	dir      string
+	mode     api.Mode
+	splits   []float64
+	result   *api.StageResult // 走行中のみ
+	last     *easportswrc.PacketEASportsWRC
+	lastSeen time.Time
+	pauseGap time.Duration
+	speech   func(text string)
+}
+
+func newStageTimer(speechCh chan<- string) *stageTimer {
+	return &stageTimer{
+		finish:   newFinishDetector(finishMethods, finishMargin),
+		pauseGap: 500 * time.Millisecond,
+		speech: func(text string) {
+			speechCh <- text
+		},
+	}
+}
+
+// Stage はステージとモードが決まった時に呼ばれる
+func (t *stageTimer) Stage(dir string, mode api.Mode) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dir = dir
+	t.mode = mode
+	t.result = nil
+}
+
+// Packet はパケット毎にスプリットと完走を記録する。読み上げはロックを外してから行う。
+func (t *stageTimer) Packet(now time.Time, pkt *easportswrc.PacketEASportsWRC) {
+	if text := t.packet(now, pkt); text != "" {
+		t.speech(text)
+	}
+}
+
+func (t *stageTimer) packet(now time.Time, pkt *easportswrc.PacketEASportsWRC) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	last := t.last
+	t.last = pkt
+	t.lastSeen = now
+	if (last == nil || last.StageCurrentDistance != 0) && pkt.StageCurrentDistance == 0 {
+		splits, err := parseSplits(splitPoints, pkt.StageLength)
+		if err != nil {
+			log.Println(err)
+		}
+		t.splits = splits
+		t.finish.Reset()
+		t.result = &api.StageResult{Mode: t.mode, Splits: []api.Split{}}
+		return ""
+	}
+	r := t.result
+	if r == nil || pkt.StageCurrentDistance == 0 {
+		return ""
+	}
+	for len(r.Splits) < len(t.splits) && pkt.StageCurrentDistance >= t.splits[len(r.Splits)] {
+		split := api.Split{Distance: t.splits[len(r.Splits)], Time: float64(pkt.StageCurrentTime)}
+		r.Splits = append(r.Splits, split)
+		log.Printf("split: %.0fm %.3fs", split.Distance, split.Time)
+		api.Publish("split", split)
+	}
+	if reason, ok := t.finish.Packet(pkt); ok {
+		return t.complete(reason, pkt)
+	}
+	return ""
+}
+
+// Tick はパケットが途絶えた時にゴール付近なら完走とする
+func (t *stageTimer) Tick(now time.Time) {
+	if text := t.tick(now); text != "" {
+		t.speech(text)
+	}
+}
+
+func (t *stageTimer) tick(now time.Time) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.result == nil || t.last == nil || now.Sub(t.lastSeen) <= t.pauseGap {
+		return ""
+	}
+	if reason, ok := t.finish.Timeout(); ok {
+		return t.complete(reason, t.last)
+	}
+	return ""
+}
+
+// complete は完走を記録し、読み上げる文言を返す(読み上げなければ空)
+func (t *stageTimer) complete(reason string, pkt *easportswrc.PacketEASportsWRC) string {
+	r := t.result
+	t.result = nil
+	r.Date = time.Now()
+	r.Reason = reason
+	r.Time = float64(pkt.StageCurrentTime)
+	prev, err := api.AddStageResult(t.dir, *r)
+	if err != nil {
+		log.Println(err)
+		return ""
+	}
+	log.Printf("stage time: %.3fs (%s)", r.Time, reason)
+	api.Publish("finish", r)
+	if !announceFinish {
+		return ""
+	}
+	if prev == nil {
+		return "フィニッシュ"
+	}
+	diff := r.Time - prev.Time
+	word := "速い"
+	if diff > 0 {
+		word = "遅い"
+	}
+	return fmt.Sprintf("フィニッシュ ベストより%.1f秒%s", math.Abs(diff), word)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nobonobo/wrc-pacenote-mod/api"
+	"github.com/nobonobo/wrc-pacenote-mod/easportswrc"
+)
+
+func TestParseSplits(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   string
+		length float64
+		want   []float64
+		err    bool
+	}{
+		{"percent", "25%,50%,75%", 8000, []float64{2000, 4000, 6000}, false},
+		{"meters", "2000,4000", 8000, []float64{2000, 4000}, false},
+		{"mixed and unordered", "5000, 25%", 8000, []float64{2000, 5000}, false},
+		{"empty entries", ",1000,,", 8000, []float64{1000}, false},
+		{"outside the stage", "0,0%,100%,9000,-5", 8000, []float64{}, false},
+		{"empty spec", "", 8000, []float64{}, false},
+		{"invalid", "25%,abc", 8000, nil, true},
+		{"invalid percent", "%", 8000, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSplits(tt.spec, tt.length)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error %v", err, tt.err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("splits = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStageTimerAnnounce(t *testing.T) {
+	announce := announceFinish
+	defer func() { announceFinish = announce }()
+	announceFinish = true
+	speech := make(chan string)
+	timer := &stageTimer{
+		finish:   newFinishDetector("distance", 100),
+		pauseGap: 500 * time.Millisecond,
+		speech: func(text string) {
+			speech <- text
+		},
+	}
+	timer.Stage(t.TempDir(), api.ModePlay)
+	run := func(stageTime float32) {
+		now := time.Now()
+		for i, d := range []float64{0, 500, 1000} {
+			timer.Packet(now, &easportswrc.PacketEASportsWRC{StageCurrentDistance: d, StageLength: 1000, StageCurrentTime: stageTime * float32(i) / 2})
+		}
+	}
+	for _, tt := range []struct {
+		stageTime float32
+		want      string
+	}{
+		{60, "フィニッシュ"},
+		{58.5, "フィニッシュ ベストより1.5秒速い"},
+		{61, "フィニッシュ ベストより2.5秒遅い"},
+	} {
+		go run(tt.stageTime)
+		select {
+		case text := <-speech:
+			if text != tt.want {
+				t.Errorf("speech = %q, want %q", text, tt.want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("finish was not announced")
+		}
+		// 読み上げ中もロックは外れている
+		timer.Tick(time.Now())
+	}
+}
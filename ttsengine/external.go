@@ -0,0 +1,159 @@
+package ttsengine
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// EngineKey は名前付き辞書で外部エンジンを指定するキー
+const EngineKey = "$engine"
+
+// External は外部コマンド(piper や espeak など)による音声合成の設定。
+// Args 中の {text} {speed} {length_scale} {rate} {pitch} {volume} は単語毎に置き換えられる。
+// {text} を含まない場合は文言を標準入力に渡す。出力は標準出力の wav か raw(16bit mono)。
+type External struct {
+	Command    string   `json:"command"`
+	Args       []string `json:"args"`
+	Format     string   `json:"format"`     // wav(既定) または raw
+	SampleRate int      `json:"sampleRate"` // raw の場合のサンプルレート(既定 22050)
+	Speed      float64  `json:"speed"`      // 基準の速さ(既定 1.0)
+	Pitch      float64  `json:"pitch"`      // 基準のピッチ(既定 0.0)
+	Volume     float64  `json:"volume"`     // 基準の音量(既定 1.0)
+}
+
+// params は単語の AQ を外部エンジンの速さ・ピッチ・音量に当てはめる
+func (x *External) params(aq AQ) (speed, pitch, volume float64) {
+	speed, pitch, volume = x.Speed, x.Pitch, x.Volume
+	if speed == 0 {
+		speed = 1.0
+	}
+	if volume == 0 {
+		volume = 1.0
+	}
+	if aq.Speed != 0 {
+		speed *= aq.Speed
+	}
+	if aq.Pitch != 0 {
+		pitch += aq.Pitch
+	}
+	if aq.Volume != 0 {
+		volume *= aq.Volume
+	}
+	return speed, pitch, volume
+}
+
+func (x *External) command(ctx context.Context, aq AQ) *exec.Cmd {
+	speed, pitch, volume := x.params(aq)
+	r := strings.NewReplacer(
+		"{text}", aq.Text,
+		"{speed}", strconv.FormatFloat(speed, 'f', 3, 64),
+		"{length_scale}", strconv.FormatFloat(1/speed, 'f', 3, 64),
+		"{rate}", strconv.Itoa(int(175*speed)),
+		"{pitch}", strconv.FormatFloat(pitch, 'f', 3, 64),
+		"{volume}", strconv.FormatFloat(volume, 'f', 3, 64),
+	)
+	args := make([]string, len(x.Args))
+	stdin := true
+	for i, v := range x.Args {
+		if strings.Contains(v, "{text}") {
+			stdin = false
+		}
+		args[i] = r.Replace(v)
+	}
+	cmd := exec.CommandContext(ctx, x.Command, args...)
+	if stdin {
+		cmd.Stdin = strings.NewReader(aq.Text + "\n")
+	}
+	return cmd
+}
+
+// Synthesis は外部コマンドで文言を合成し、音量を反映した wav を返す
+func (x *External) Synthesis(ctx context.Context, aq AQ) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := x.command(ctx, aq)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %w: %s", x.Command, err, strings.TrimSpace(stderr.String()))
+	}
+	_, _, volume := x.params(aq)
+	var channels, bits, rate int
+	var pcm []byte
+	if x.Format == "raw" {
+		channels, bits, rate = 1, 16, x.SampleRate
+		if rate == 0 {
+			rate = 22050
+		}
+		pcm = stdout.Bytes()
+	} else {
+		var err error
+		channels, bits, rate, pcm, err = parseWav(stdout.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", x.Command, err)
+		}
+	}
+	if bits == 16 && volume != 1.0 {
+		for i := 0; i+1 < len(pcm); i += 2 {
+			v := float64(int16(binary.LittleEndian.Uint16(pcm[i:]))) * volume
+			v = math.Max(math.MinInt16, math.Min(math.MaxInt16, v))
+			binary.LittleEndian.PutUint16(pcm[i:], uint16(int16(v)))
+		}
+	}
+	return buildWav(channels, bits, rate, pcm), nil
+}
+
+// parseWav は wav の形式とデータを取り出す。ストリーム出力でサイズが不正な場合はファイル末尾までをデータとする。
+func parseWav(b []byte) (channels, bits, rate int, data []byte, err error) {
+	if len(b) < 12 || string(b[0:4]) != "RIFF" || string(b[8:12]) != "WAVE" {
+		return 0, 0, 0, nil, fmt.Errorf("invalid wav output")
+	}
+	for pos := 12; pos+8 <= len(b); {
+		id := string(b[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(b[pos+4 : pos+8]))
+		body := pos + 8
+		switch id {
+		case "fmt ":
+			if body+16 > len(b) {
+				return 0, 0, 0, nil, fmt.Errorf("invalid wav fmt chunk")
+			}
+			channels = int(binary.LittleEndian.Uint16(b[body+2:]))
+			rate = int(binary.LittleEndian.Uint32(b[body+4:]))
+			bits = int(binary.LittleEndian.Uint16(b[body+14:]))
+		case "data":
+			if size <= 0 || body+size > len(b) {
+				size = len(b) - body
+			}
+			if channels == 0 {
+				return 0, 0, 0, nil, fmt.Errorf("wav fmt chunk not found")
+			}
+			return channels, bits, rate, b[body : body+size], nil
+		}
+		pos = body + size + size%2
+	}
+	return 0, 0, 0, nil, fmt.Errorf("wav data chunk not found")
+}
+
+func buildWav(channels, bits, rate int, data []byte) []byte {
+	var buf bytes.Buffer
+	le := binary.LittleEndian
+	buf.WriteString("RIFF")
+	binary.Write(&buf, le, uint32(36+len(data)))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, le, uint32(16))
+	binary.Write(&buf, le, uint16(1))
+	binary.Write(&buf, le, uint16(channels))
+	binary.Write(&buf, le, uint32(rate))
+	binary.Write(&buf, le, uint32(rate*channels*bits/8))
+	binary.Write(&buf, le, uint16(channels*bits/8))
+	binary.Write(&buf, le, uint16(bits))
+	buf.WriteString("data")
+	binary.Write(&buf, le, uint32(len(data)))
+	buf.Write(data)
+	return buf.Bytes()
+}
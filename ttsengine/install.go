@@ -0,0 +1,496 @@
+package ttsengine
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultVoiceVoxVersion = "0.15.0-preview.13"
+	downloadBase           = "https://github.com/VOICEVOX/voicevox_core/releases/download/"
+	versionFile            = "VERSION"
+	sumsFile               = "SHA256SUMS"
+)
+
+// InstallOptions は voicevox_core のインストール方法
+type InstallOptions struct {
+	Version  string                  // voicevox_core のバージョン
+	Source   string                  // ローカルのアーカイブ(.zip/.tar.gz)またはフォルダ、空ならダウンロード
+	Sums     string                  // SHA256SUMS のパス、空ならソースに同梱のものを使う
+	NoVerify bool                    // ソースに SHA256SUMS が無くてもチェックサムの照合なしでインストールする
+	Progress func(p InstallProgress) // 進捗の通知先
+}
+
+// InstallProgress はインストールの進捗
+type InstallProgress struct {
+	Step  string `json:"step"`
+	Done  int64  `json:"done"`
+	Total int64  `json:"total"`
+}
+
+func (o InstallOptions) report(step string, done, total int64) {
+	if o.Progress != nil {
+		o.Progress(InstallProgress{Step: step, Done: done, Total: total})
+	}
+}
+
+type progressWriter struct {
+	opts  InstallOptions
+	step  string
+	done  int64
+	total int64
+	last  time.Time
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	w.done += int64(len(b))
+	if now := time.Now(); now.Sub(w.last) > 200*time.Millisecond || w.done == w.total {
+		w.last = now
+		w.opts.report(w.step, w.done, w.total)
+	}
+	return len(b), nil
+}
+
+func download(u, folder string, opts InstallOptions) error {
+	fname := filepath.Join(folder, filepath.Base(u))
+	resp, err := http.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download failed: %s: %s", u, resp.Status)
+	}
+	f, err := os.Create(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	pw := &progressWriter{opts: opts, step: "download", total: resp.ContentLength}
+	if _, err := io.Copy(io.MultiWriter(f, pw), resp.Body); err != nil {
+		return err
+	}
+	return nil
+}
+
+func installedVersion(folder string) string {
+	b, err := os.ReadFile(filepath.Join(folder, versionFile))
+	if err != nil {
+		// VERSION を残す前のインストール
+		return DefaultVoiceVoxVersion
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func isInstalled(folder, version string) bool {
+	for _, f := range requiredFiles {
+		if _, err := os.Stat(filepath.Join(folder, f)); err != nil {
+			return false
+		}
+	}
+	return version == "" || installedVersion(folder) == version
+}
+
+// downloadVoiceVox は公式のダウンローダーで dir/voicevox_core にダウンロードし、そのパスを返す
+func downloadVoiceVox(dir string, opts InstallOptions) (string, error) {
+	if err := download(downloadBase+opts.Version+"/"+downloader, dir, opts); err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	if err := os.Chmod(filepath.Join(dir, downloader), 0o755); err != nil {
+		return "", err
+	}
+	cmd := exec.CommandContext(ctx, "./"+downloader,
+		"--device", "cpu", "--version", opts.Version,
+	)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	cmd.Stdout = os.Stdout
+	log.Println("download voicevox_core:", dir)
+	opts.report("install", 0, 0)
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	root := filepath.Join(dir, "voicevox_core")
+	if opts.Sums != "" {
+		if err := verify(root, opts.Sums, opts); err != nil {
+			return "", err
+		}
+	}
+	return root, nil
+}
+
+// safeJoin はアーカイブ内のパスが展開先の外に出ないことを確かめる
+func safeJoin(dir, name string) (string, error) {
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if p != dir && !strings.HasPrefix(p, dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid path in archive: %q", name)
+	}
+	return p, nil
+}
+
+func writeFile(dst string, r io.Reader, mode fs.FileMode, pw *progressWriter) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode|0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(io.MultiWriter(f, pw), r); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func extractZip(src, dst string, opts InstallOptions) error {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	pw := &progressWriter{opts: opts, step: "extract"}
+	for _, f := range zr.File {
+		pw.total += int64(f.UncompressedSize64)
+	}
+	for _, f := range zr.File {
+		p, err := safeJoin(dst, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(p, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = writeFile(p, r, f.Mode().Perm(), pw)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractTarGz(src, dst string, opts InstallOptions) error {
+	fp, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+	info, err := fp.Stat()
+	if err != nil {
+		return err
+	}
+	// 展開後のサイズは事前に分からないのでアーカイブの読み込み量で進捗を出す
+	pr := &progressWriter{opts: opts, step: "extract", total: info.Size()}
+	gr, err := gzip.NewReader(io.TeeReader(fp, pr))
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p, err := safeJoin(dst, h.Name)
+		if err != nil {
+			return err
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(p, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFile(p, tr, fs.FileMode(h.Mode).Perm(), &progressWriter{}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func copyDir(src, dst string, opts InstallOptions) error {
+	pw := &progressWriter{opts: opts, step: "copy"}
+	if err := filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		pw.total += info.Size()
+		return nil
+	}); err != nil {
+		return err
+	}
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		r, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return writeFile(target, r, info.Mode().Perm(), pw)
+	})
+}
+
+// findRoot は展開したファイルの中から voicevox_core 本体のあるフォルダを探す
+func findRoot(dir string) (string, error) {
+	root := ""
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if root == "" && !d.IsDir() && d.Name() == coreLibrary {
+			root = filepath.Dir(p)
+			return fs.SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if root == "" {
+		return "", fmt.Errorf("%s not found in %q", coreLibrary, dir)
+	}
+	return root, nil
+}
+
+// hashFile はファイルの SHA256 を求める
+func hashFile(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sumsTargets は必須ファイルとして照合するファイルの相対パス。フォルダは配下の全ファイル。
+func sumsTargets(folder string) ([]string, error) {
+	res := []string{}
+	for _, f := range requiredFiles {
+		root := filepath.Join(folder, f)
+		if err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(folder, p)
+			if err != nil {
+				return err
+			}
+			res = append(res, filepath.ToSlash(rel))
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func readSums(fpath string) (map[string]string, error) {
+	fp, err := os.Open(fpath)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	sums := map[string]string{}
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(fields[1], "*"), "./")
+		sums[name] = strings.ToLower(fields[0])
+	}
+	return sums, scanner.Err()
+}
+
+// verify は必須ファイルのチェックサムを SHA256SUMS(sha256sum の出力形式)と照合する。
+// フォルダの必須ファイルは配下の全ファイルが載っている必要がある。
+func verify(folder, sumsPath string, opts InstallOptions) error {
+	sums, err := readSums(sumsPath)
+	if err != nil {
+		return err
+	}
+	targets, err := sumsTargets(folder)
+	if err != nil {
+		return err
+	}
+	listed := map[string]bool{}
+	for i, f := range targets {
+		listed[f] = true
+		opts.report("verify", int64(i), int64(len(targets)))
+		want, ok := sums[f]
+		if !ok {
+			return fmt.Errorf("checksum not listed: %q", f)
+		}
+		got, err := hashFile(filepath.Join(folder, filepath.FromSlash(f)))
+		if err != nil {
+			return err
+		}
+		if got != want {
+			return fmt.Errorf("checksum mismatch: %q", f)
+		}
+	}
+	// 載っているのに展開されていないファイルは欠けている
+	for f := range sums {
+		for _, r := range requiredFiles {
+			if (f == r || strings.HasPrefix(f, r+"/")) && !listed[f] {
+				return fmt.Errorf("missing file: %q", f)
+			}
+		}
+	}
+	opts.report("verify", int64(len(targets)), int64(len(targets)))
+	return nil
+}
+
+// extractLocal はローカルのアーカイブまたはフォルダを dir に展開して照合し、voicevox_core 本体のパスを返す
+func extractLocal(dir string, opts InstallOptions) (string, error) {
+	info, err := os.Stat(opts.Source)
+	if err != nil {
+		return "", err
+	}
+	log.Println("install voicevox_core from:", opts.Source)
+	switch name := strings.ToLower(opts.Source); {
+	case info.IsDir():
+		err = copyDir(opts.Source, dir, opts)
+	case strings.HasSuffix(name, ".zip"):
+		err = extractZip(opts.Source, dir, opts)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		err = extractTarGz(opts.Source, dir, opts)
+	default:
+		err = fmt.Errorf("unsupported archive: %q", opts.Source)
+	}
+	if err != nil {
+		return "", err
+	}
+	root, err := findRoot(dir)
+	if err != nil {
+		return "", err
+	}
+	sums := opts.Sums
+	if sums == "" {
+		sums = filepath.Join(root, sumsFile)
+	}
+	if _, err := os.Stat(sums); err != nil {
+		if opts.Sums != "" || !opts.NoVerify {
+			return "", fmt.Errorf("%s not found (use -voicevox-sums, or -voicevox-no-verify to skip verification): %w", sumsFile, err)
+		}
+		log.Printf("%s not found, checksum verification skipped", sumsFile)
+		return root, nil
+	}
+	return root, verify(root, sums, opts)
+}
+
+// replace は folder を src で置き換える。失敗したら元のインストールに戻す。
+func replace(src, folder, backup string) error {
+	if _, err := os.Stat(folder); err == nil {
+		if err := os.Rename(folder, backup); err != nil {
+			return err
+		}
+	} else {
+		backup = ""
+	}
+	if err := os.Rename(src, folder); err != nil {
+		if backup != "" {
+			if err := os.Rename(backup, folder); err != nil {
+				log.Println(err)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// Install は voicevox_core が無いか、バージョンが違う場合にインストールする。
+// 一時フォルダに展開して照合が済んでから既存のインストールと入れ替える。
+func Install(folder string, opts InstallOptions) error {
+	if opts.Version == "" {
+		opts.Version = DefaultVoiceVoxVersion
+	}
+	if isInstalled(folder, opts.Version) {
+		opts.report("done", 1, 1)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(folder), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(folder), "voicevox_core-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, "new")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	var root string
+	if opts.Source != "" {
+		root, err = extractLocal(dir, opts)
+	} else {
+		root, err = downloadVoiceVox(dir, opts)
+	}
+	if err != nil {
+		return err
+	}
+	if !isInstalled(root, "") {
+		return fmt.Errorf("voicevox_core install incomplete: %q", root)
+	}
+	if err := os.WriteFile(filepath.Join(root, versionFile), []byte(opts.Version+"\n"), 0o644); err != nil {
+		return err
+	}
+	if err := replace(root, folder, filepath.Join(tmp, "old")); err != nil {
+		return err
+	}
+	opts.report("done", 1, 1)
+	return nil
+}
@@ -0,0 +1,120 @@
+package ttsengine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeCore は requiredFiles を揃えたフォルダを作り、SHA256SUMS の内容を返す
+func fakeCore(t *testing.T, dir, content string) string {
+	t.Helper()
+	sums := ""
+	for _, f := range requiredFiles {
+		p := filepath.Join(dir, f)
+		if f == "model" || strings.HasPrefix(f, "open_jtalk") {
+			p = filepath.Join(p, "data.bin")
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content+f), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		sum, err := hashFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel, _ := filepath.Rel(dir, p)
+		sums += fmt.Sprintf("%s  %s\n", sum, filepath.ToSlash(rel))
+	}
+	return sums
+}
+
+func TestInstallLocal(t *testing.T) {
+	base := t.TempDir()
+	folder := filepath.Join(base, "voicevox_core")
+	fakeCore(t, folder, "old")
+	if err := os.WriteFile(filepath.Join(folder, versionFile), []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// 既存のインストールの中にあるソースは失敗しても消えない
+	src := filepath.Join(folder, "source", "voicevox_core")
+	sums := fakeCore(t, src, "new")
+	badSums := filepath.Join(base, "bad.txt")
+	if err := os.WriteFile(badSums, []byte(strings.ReplaceAll(sums, "a", "b")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bundled := filepath.Join(base, "bundled", "voicevox_core")
+	bundledSums := fakeCore(t, bundled, "newer")
+	if err := os.WriteFile(filepath.Join(bundled, sumsFile), []byte(bundledSums), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    InstallOptions
+		version string // 失敗した場合は元のインストールが残る
+	}{
+		{"no checksums", InstallOptions{Version: "new", Source: src}, "old"},
+		{"checksum mismatch", InstallOptions{Version: "new", Source: src, Sums: badSums}, "old"},
+		{"no verify", InstallOptions{Version: "new", Source: src, NoVerify: true}, "new"},
+		{"bundled checksums", InstallOptions{Version: "newer", Source: bundled}, "newer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Install(folder, tt.opts)
+			if (err == nil) != (tt.version == tt.opts.Version) {
+				t.Fatalf("err = %v", err)
+			}
+			if got := installedVersion(folder); got != tt.version {
+				t.Errorf("version = %q, want %q", got, tt.version)
+			}
+			if !isInstalled(folder, "") {
+				t.Error("install is broken")
+			}
+			entries, _ := filepath.Glob(filepath.Join(base, "voicevox_core-*"))
+			if len(entries) != 0 {
+				t.Errorf("temporary folders remain: %q", entries)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name  string
+		edit  func(dir, sums string) string // フォルダを変更して SHA256SUMS の内容を返す
+		valid bool
+	}{
+		{"sha256sum output", func(dir, sums string) string { return sums }, true},
+		{"binary mode and ./ prefix", func(dir, sums string) string {
+			return strings.ReplaceAll(sums, "  ", " *./")
+		}, true},
+		{"unlisted file in a folder", func(dir, sums string) string {
+			os.WriteFile(filepath.Join(dir, "model", "extra.bin"), []byte("extra"), 0o644)
+			return sums
+		}, false},
+		{"listed file is missing", func(dir, sums string) string {
+			return sums + fmt.Sprintf("%064d  model/missing.bin\n", 0)
+		}, false},
+		{"modified file", func(dir, sums string) string {
+			os.WriteFile(filepath.Join(dir, "model", "data.bin"), []byte("modified"), 0o644)
+			return sums
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			sums := filepath.Join(t.TempDir(), sumsFile)
+			if err := os.WriteFile(sums, []byte(tt.edit(dir, fakeCore(t, dir, "core"))), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := verify(dir, sums, InstallOptions{}); (err == nil) != tt.valid {
+				t.Errorf("err = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package ttsengine
+
+const (
+	downloader  = "download-linux-x64"
+	coreLibrary = "libvoicevox_core.so"
+)
+
+var requiredFiles = []string{
+	coreLibrary,
+	"libonnxruntime.so.1.14.0",
+	"open_jtalk_dic_utf_8-1.11",
+	"model",
+}
@@ -0,0 +1,14 @@
+package ttsengine
+
+const (
+	downloader  = "download-windows-x64.exe"
+	coreLibrary = "voicevox_core.dll"
+)
+
+var requiredFiles = []string{
+	coreLibrary,
+	"onnxruntime_providers_shared.dll",
+	"onnxruntime.dll",
+	"open_jtalk_dic_utf_8-1.11",
+	"model",
+}
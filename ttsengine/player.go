@@ -0,0 +1,83 @@
+//go:build !headless
+
+package ttsengine
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aethiopicuschan/nanoda"
+	"github.com/ebitengine/oto/v3"
+	"github.com/hajimehoshi/ebiten/v2/audio/wav"
+)
+
+func (e *Engine) play(ctxOto *oto.Context, r io.Reader) {
+	p := ctxOto.NewPlayer(r)
+	defer p.Close()
+	p.Play()
+	for p.IsPlaying() {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (e *Engine) playback(ctxOto *oto.Context, q nanoda.AudioQuery) error {
+	w, err := e.synthesizer.Synthesis(q, nanoda.StyleId(e.opts.ActorID))
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	decoded, err := wav.DecodeWithoutResampling(w)
+	if err != nil {
+		return err
+	}
+	e.play(ctxOto, decoded)
+	return nil
+}
+
+// speak は単語を使用中の辞書のエンジンで読み上げる
+func (e *Engine) speak(ctx context.Context, ctxOto *oto.Context, word string) error {
+	d := e.current()
+	if d.external != nil {
+		aq := d.externalAQ(word)
+		b, err := d.external.Synthesis(ctx, aq)
+		if err != nil {
+			return err
+		}
+		decoded, err := wav.DecodeWithSampleRate(outputSampleRate, bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		e.play(ctxOto, decoded)
+		return nil
+	}
+	q, err := e.lookup(d, word)
+	if err != nil {
+		return err
+	}
+	return e.playback(ctxOto, q)
+}
+
+// Start は in から受け取った文言を読み上げ続ける
+func (e *Engine) Start(ctx context.Context, ctxOto *oto.Context, in <-chan string) error {
+	log.Println("TTS Engine started")
+	defer log.Println("TTS Engine stopped")
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case words := <-in:
+			for _, v := range strings.Fields(words) {
+				if v == "unknown" {
+					continue
+				}
+				if err := e.speak(ctx, ctxOto, v); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package ttsengine
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aethiopicuschan/nanoda"
+)
+
+// RenderSampleRate は Render が返す音声のサンプルレート
+const RenderSampleRate = outputSampleRate
+
+// named は名前付き辞書を使用中の辞書を切り替えずに読み込む
+func (e *Engine) named(name string) (*voiceDict, error) {
+	if name == "" {
+		name = e.opts.DictionaryName
+	}
+	e.mu.Lock()
+	d, ok := e.dicts[name]
+	e.mu.Unlock()
+	if ok {
+		return d, nil
+	}
+	d, err := e.loadDict(name)
+	if err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dicts[name] = d
+	return d, nil
+}
+
+// Render は文言を辞書 name で合成し、RenderSampleRate のモノラル16bit のサンプルを返す。再生はしない。
+func (e *Engine) Render(ctx context.Context, name, text string) ([]int16, error) {
+	d, err := e.named(name)
+	if err != nil {
+		return nil, err
+	}
+	res := []int16{}
+	for _, word := range strings.Fields(text) {
+		if word == "unknown" {
+			continue
+		}
+		var b []byte
+		if d.external != nil {
+			aq := d.externalAQ(word)
+			if b, err = d.external.Synthesis(ctx, aq); err != nil {
+				return nil, err
+			}
+		} else {
+			q, err := e.lookup(d, word)
+			if err != nil {
+				return nil, err
+			}
+			if b, err = e.synthesize(q); err != nil {
+				return nil, err
+			}
+		}
+		samples, err := decodeMono(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", word, err)
+		}
+		res = append(res, samples...)
+	}
+	return res, nil
+}
+
+func (e *Engine) synthesize(q nanoda.AudioQuery) ([]byte, error) {
+	w, err := e.synthesizer.Synthesis(q, nanoda.StyleId(e.opts.ActorID))
+	if err != nil {
+		return nil, err
+	}
+	defer w.Close()
+	return io.ReadAll(w)
+}
+
+// decodeMono は16bit の wav をモノラルにして RenderSampleRate に変換する
+func decodeMono(b []byte) ([]int16, error) {
+	channels, bits, rate, data, err := parseWav(b)
+	if err != nil {
+		return nil, err
+	}
+	if bits != 16 || channels < 1 || rate <= 0 {
+		return nil, fmt.Errorf("unsupported wav format: %dch %dbit %dHz", channels, bits, rate)
+	}
+	frames := len(data) / 2 / channels
+	mono := make([]float64, frames)
+	for i := range mono {
+		sum := 0.0
+		for c := 0; c < channels; c++ {
+			sum += float64(int16(binary.LittleEndian.Uint16(data[(i*channels+c)*2:])))
+		}
+		mono[i] = sum / float64(channels)
+	}
+	// 線形補間でサンプルレートを合わせる
+	n := frames * RenderSampleRate / rate
+	res := make([]int16, n)
+	for i := range res {
+		pos := float64(i) * float64(rate) / RenderSampleRate
+		j := int(pos)
+		v := mono[j]
+		if j+1 < frames {
+			v += (mono[j+1] - mono[j]) * (pos - float64(j))
+		}
+		res[i] = int16(v)
+	}
+	return res, nil
+}
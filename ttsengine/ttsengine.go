@@ -1,172 +1,154 @@
 package ttsengine
 
 import (
-	"context"
-	"io"
+	"fmt"
 	"log"
-	"net/http"
-	"net/url"
-	"os"
-	"os/exec"
 	"path/filepath"
-	"strings"
-	"time"
+	"sync"
 
 	"github.com/aethiopicuschan/nanoda"
-	"github.com/ebitengine/oto/v3"
-	"github.com/hajimehoshi/ebiten/v2/audio/wav"
-	"github.com/nobonobo/wrc-pacenote-mod/config"
 )
 
-const (
-	downloadUrl = "https://github.com/VOICEVOX/voicevox_core/releases/download/0.15.0-preview.13/download-windows-x64.exe"
-)
+// outputSampleRate は VOICEVOX の出力サンプルレート。外部エンジンの出力もこれにそろえる。
+const outputSampleRate = 24000
 
-func download(u, folder string) error {
-	info, err := url.Parse(u)
-	if err != nil {
-		return err
-	}
-	fname := filepath.Join(folder, filepath.Base(info.Path))
-	resp, err := http.Get(u)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	f, err := os.Create(fname)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	if _, err := io.Copy(f, resp.Body); err != nil {
-		return err
-	}
-	return nil
+// voiceDict は辞書1つ分。外部エンジン指定があれば VOICEVOX 用のコンパイルはしない。
+type voiceDict struct {
+	audio    AudioDict
+	words    map[string]AQ
+	external *External
 }
 
-func isInstalled(folder string) bool {
-	files := []string{
-		"voicevox_core.dll",
-		"onnxruntime_providers_shared.dll",
-		"onnxruntime.dll",
-		"open_jtalk_dic_utf_8-1.11",
-		"model",
-	}
-	for _, f := range files {
-		if _, err := os.Stat(filepath.Join(folder, f)); err != nil {
-			return false
-		}
+// externalAQ は外部エンジンで読み上げる単語の定義。読みが無ければ単語の綴りのまま読む。
+func (d *voiceDict) externalAQ(word string) AQ {
+	aq := d.words[word]
+	if aq.Text == "" {
+		aq.Text = word
 	}
-	return true
+	return aq
 }
 
-func installVoiceVox(folder string) error {
-	if err := download(downloadUrl, folder); err != nil {
-		return err
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
-	defer cancel()
-	cmd := exec.CommandContext(ctx, "./download-windows-x64.exe",
-		"--device", "cpu", "--version", "0.15.0-preview.13",
-	)
-	cmd.Dir = folder
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	log.Println("install voicevox_core:", folder)
-	if err := cmd.Run(); err != nil {
-		return err
-	}
-	return nil
+// Engine は VOICEVOX による読み上げエンジン
+type Engine struct {
+	opts        Options
+	synthesizer nanoda.Synthesizer
+	mu          sync.Mutex
+	dictionary  *voiceDict            // 使用中の辞書
+	dictName    string                // 使用中の名前付き辞書
+	dicts       map[string]*voiceDict // 読み込み済みの辞書
+	stageDict   AudioDict
 }
 
-func init() {
-	folder := config.Config.VoiceVoxDir
-	if isInstalled(folder) {
-		return
+// New は voicevox_core と辞書を読み込んでエンジンを作る
+func New(opts Options) (*Engine, error) {
+	if opts.AutoInstall {
+		if err := Install(opts.VoiceVoxDir, opts.Install); err != nil {
+			return nil, err
+		}
+	} else if !isInstalled(opts.VoiceVoxDir, opts.Install.Version) {
+		return nil, fmt.Errorf("voicevox_core is not installed: %q", opts.VoiceVoxDir)
 	}
-	if err := os.RemoveAll(folder); err != nil {
-		log.Fatal(err)
+	v, err := nanoda.NewVoicevox(
+		filepath.Join(opts.VoiceVoxDir, coreLibrary),
+		filepath.Join(opts.VoiceVoxDir, "open_jtalk_dic_utf_8-1.11"),
+		filepath.Join(opts.VoiceVoxDir, "model"))
+	if err != nil {
+		return nil, err
+	}
+	s, err := v.NewSynthesizer()
+	if err != nil {
+		return nil, err
 	}
-	if err := installVoiceVox(filepath.Dir(folder)); err != nil {
-		log.Fatal(err)
+	e := &Engine{
+		opts:        opts,
+		synthesizer: s,
+		dicts:       map[string]*voiceDict{},
+		stageDict:   AudioDict{},
 	}
+	if err := s.LoadModelsFromStyleId(nanoda.StyleId(opts.ActorID)); err != nil {
+		s.Close()
+		return nil, err
+	}
+	d, err := e.loadDict(opts.DictionaryName)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	e.dictionary = d
+	e.dictName = opts.DictionaryName
+	e.dicts[opts.DictionaryName] = d
+	return e, nil
 }
 
-func playback(ctxOto *oto.Context, s nanoda.Synthesizer, q nanoda.AudioQuery) error {
-	w, err := s.Synthesis(q, nanoda.StyleId(ActorID))
+func (e *Engine) loadDict(name string) (*voiceDict, error) {
+	words, external, err := LoadNamedDictionary(e.opts.Dictionary, e.opts.DictionaryDir, name)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer w.Close()
-	decoded, err := wav.DecodeWithoutResampling(w)
+	if external != nil {
+		log.Printf("dictionary %q uses external engine: %s", name, external.Command)
+		return &voiceDict{words: words, external: external}, nil
+	}
+	audio, err := e.compile(words)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	p := ctxOto.NewPlayer(decoded)
-	defer p.Close()
-	p.Play()
-	for p.IsPlaying() {
-		time.Sleep(10 * time.Millisecond)
+	return &voiceDict{audio: audio, words: words}, nil
+}
+
+// UseDictionary は名前付き辞書に切り替える。空なら -dictionary の辞書を使う。
+func (e *Engine) UseDictionary(name string) error {
+	if name == "" {
+		name = e.opts.DictionaryName
+	}
+	e.mu.Lock()
+	d, ok := e.dicts[name]
+	current := e.dictName
+	e.mu.Unlock()
+	if current == name {
+		return nil
+	}
+	if !ok {
+		var err error
+		if d, err = e.loadDict(name); err != nil {
+			return err
+		}
 	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dicts[name] = d
+	e.dictionary = d
+	e.dictName = name
+	log.Printf("dictionary: %q", name)
 	return nil
 }
 
-var (
-	dictionary  = map[string]nanoda.AudioQuery{}
-	synthesizer nanoda.Synthesizer
-)
+func (e *Engine) current() *voiceDict {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.dictionary
+}
 
-func StartEngine(ctx context.Context, ctxOto *oto.Context, in <-chan string) error {
-	v, err := nanoda.NewVoicevox(
-		filepath.Join(config.Config.VoiceVoxDir, "voicevox_core.dll"),
-		filepath.Join(config.Config.VoiceVoxDir, "open_jtalk_dic_utf_8-1.11"),
-		filepath.Join(config.Config.VoiceVoxDir, "model"))
-	if err != nil {
-		return err
-	}
-	s, err := v.NewSynthesizer()
-	if err != nil {
-		return err
+func (e *Engine) Close() {
+	e.synthesizer.Close()
+}
+
+func (e *Engine) lookup(d *voiceDict, word string) (nanoda.AudioQuery, error) {
+	e.mu.Lock()
+	q, ok := d.audio[word]
+	if !ok {
+		q, ok = e.stageDict[word]
 	}
-	defer s.Close()
-	synthesizer = s
-	if err := s.LoadModelsFromStyleId(nanoda.StyleId(ActorID)); err != nil {
-		return err
+	e.mu.Unlock()
+	if ok {
+		return q, nil
 	}
-	d, err := Init(s, Dict)
+	q, err := e.makeAudioQuery(word)
 	if err != nil {
-		return err
-	}
-	dictionary = d
-	log.Println("TTS Engine started")
-	defer log.Println("TTS Engine stopped")
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		case words := <-in:
-			for _, v := range strings.Fields(words) {
-				if v == "unknown" {
-					continue
-				}
-				var qm nanoda.AudioQuery
-				var ok bool
-				qm, ok = dictionary[v]
-				if !ok {
-					qm, ok = stageDict[v]
-				}
-				if !ok {
-					q, err := makeAudioQuery(s, v)
-					if err != nil {
-						return err
-					}
-					dictionary[v] = q
-					qm = q
-				}
-				if err := playback(ctxOto, s, qm); err != nil {
-					return err
-				}
-			}
-		}
+		return nanoda.AudioQuery{}, err
 	}
+	e.mu.Lock()
+	d.audio[word] = q
+	e.mu.Unlock()
+	return q, nil
 }
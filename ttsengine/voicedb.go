@@ -4,34 +4,63 @@ import (
 	_ "embed"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/aethiopicuschan/nanoda"
-	"github.com/nobonobo/wrc-pacenote-mod/config"
 )
 
-var (
-	ActorID           = 3
-	Pitch             = 0.0
-	Intnation         = 1.0
-	Speed             = 1.4
-	Volume            = 1.8
-	Pause             = 0.1
-	PrePhonemeLength  = 0.0
-	PostPhonemeLength = 0.0
-)
+// Options は TTS エンジンの設定
+type Options struct {
+	VoiceVoxDir       string // voicevox_core のインストール先
+	Dictionary        string // dictionary.json のパス
+	DictionaryDir     string // 名前付き辞書(名前.json)のフォルダ
+	DictionaryName    string // 既定の名前付き辞書(空なら dictionary.json のみ)
+	AutoInstall       bool   // 未インストールならインストールする
+	Install           InstallOptions
+	ActorID           int
+	Pitch             float64
+	Intnation         float64
+	Speed             float64
+	Volume            float64
+	Pause             float64
+	PrePhonemeLength  float64
+	PostPhonemeLength float64
+}
+
+func DefaultOptions() Options {
+	return Options{
+		AutoInstall:       true,
+		Install:           InstallOptions{Version: DefaultVoiceVoxVersion},
+		ActorID:           3,
+		Pitch:             0.0,
+		Intnation:         1.0,
+		Speed:             1.4,
+		Volume:            1.8,
+		Pause:             0.1,
+		PrePhonemeLength:  0.0,
+		PostPhonemeLength: 0.0,
+	}
+}
 
-func init() {
-	flag.IntVar(&ActorID, "actor", ActorID, "actor id")
-	flag.Float64Var(&Pitch, "pitch", Pitch, "pitch")
-	flag.Float64Var(&Intnation, "intnation", Volume, "intnation")
-	flag.Float64Var(&Speed, "speed", Speed, "base speed")
-	flag.Float64Var(&Volume, "volume", Volume, "volume magnification")
-	flag.Float64Var(&Pause, "pause", Pause, "pause magnification")
-	flag.Float64Var(&PrePhonemeLength, "pre-phoneme", PrePhonemeLength, "pre-phoneme-length")
-	flag.Float64Var(&PostPhonemeLength, "post-phoneme", PostPhonemeLength, "post-phoneme-length")
+// RegisterFlags は音声パラメータとインストール方法のフラグを登録する
+func (o *Options) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Install.Version, "voicevox-version", o.Install.Version, "voicevox_core version to install")
+	fs.StringVar(&o.Install.Source, "voicevox-source", o.Install.Source, "install voicevox_core from a local archive (.zip/.tar.gz) or directory instead of downloading")
+	fs.StringVar(&o.Install.Sums, "voicevox-sums", o.Install.Sums, "SHA256SUMS file to verify voicevox_core files")
+	fs.BoolVar(&o.Install.NoVerify, "voicevox-no-verify", o.Install.NoVerify, "install from -voicevox-source without checksum verification when it has no SHA256SUMS")
+	fs.StringVar(&o.DictionaryName, "dictionary", o.DictionaryName, "named dictionary used when the stage has no dictionary setting")
+	fs.IntVar(&o.ActorID, "actor", o.ActorID, "actor id")
+	fs.Float64Var(&o.Pitch, "pitch", o.Pitch, "pitch")
+	fs.Float64Var(&o.Intnation, "intnation", o.Intnation, "intnation")
+	fs.Float64Var(&o.Speed, "speed", o.Speed, "base speed")
+	fs.Float64Var(&o.Volume, "volume", o.Volume, "volume magnification")
+	fs.Float64Var(&o.Pause, "pause", o.Pause, "pause magnification")
+	fs.Float64Var(&o.PrePhonemeLength, "pre-phoneme", o.PrePhonemeLength, "pre-phoneme-length")
+	fs.Float64Var(&o.PostPhonemeLength, "post-phoneme", o.PostPhonemeLength, "post-phoneme-length")
 }
 
 type AQ struct {
@@ -44,34 +73,9 @@ type AQ struct {
 
 type AudioDict map[string]nanoda.AudioQuery
 
-var (
-	Dict      map[string]AQ
-	stageDict = NewDict()
-)
-
 //go:embed base.json
 var base []byte
 
-func NewDict() AudioDict {
-	return map[string]nanoda.AudioQuery{}
-}
-
-func (d AudioDict) Add(s string) {
-	if _, ok := d[s]; ok {
-		return
-	}
-	q, err := makeAudioQuery(synthesizer, s)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	d[s] = q
-}
-
-func SetDict(d AudioDict) {
-	stageDict = d
-}
-
 func writeDictionary(dstName string) error {
 	os.MkdirAll(filepath.Dir(dstName), 0777)
 	dst, err := os.Create(dstName) // コピー先ファイルを作成する
@@ -88,51 +92,117 @@ func writeDictionary(dstName string) error {
 	return nil
 }
 
-func init() {
-	fpath := filepath.Join(config.Config.LogDir, "dictionary.json")
+// LoadDictionary は dictionary.json を読み込む。無ければ base.json から作成する。
+func LoadDictionary(fpath string) (map[string]AQ, error) {
 	if _, err := os.Stat(fpath); err != nil {
 		if !os.IsNotExist(err) {
-			log.Fatal(err)
+			return nil, err
 		}
 		if err := writeDictionary(fpath); err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 	}
 	log.Println("loading dictionary.json")
 	defer log.Println("dictionary.json loading completed")
+	// dictionary.json に無い単語は base.json の定義を使う
+	dict := map[string]AQ{}
+	if err := json.Unmarshal(base, &dict); err != nil {
+		return nil, err
+	}
 	fp, err := os.Open(fpath)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer fp.Close()
-	if err := json.NewDecoder(fp).Decode(&Dict); err != nil {
-		log.Fatal(err)
+	if err := json.NewDecoder(fp).Decode(&dict); err != nil {
+		return nil, err
 	}
+	return dict, nil
+}
+
+// ValidDictionaryName は名前付き辞書の名前として使えるかどうか
+func ValidDictionaryName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\:`)
 }
 
-func makeAudioQuery(s nanoda.Synthesizer, text string) (nanoda.AudioQuery, error) {
-	q, err := s.CreateAudioQuery(text, nanoda.StyleId(ActorID))
+// LoadNamedDictionary は base.json、dictionary.json、名前付き辞書の順に定義を重ねて読み込む。
+// 名前付き辞書に "$engine" があれば外部エンジンの設定も返す。外部エンジンでは VOICEVOX 向けの
+// 読み(text)は使えないので、名前付き辞書に無い単語は速さなどのパラメータだけを引き継ぐ。
+func LoadNamedDictionary(fpath, dir, name string) (map[string]AQ, *External, error) {
+	dict, err := LoadDictionary(fpath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if name == "" {
+		return dict, nil, nil
+	}
+	if !ValidDictionaryName(name) {
+		return nil, nil, fmt.Errorf("invalid dictionary name: %q", name)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, name+".json"))
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Printf("loading dictionary: %q", name)
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, nil, fmt.Errorf("%s.json: %w", name, err)
+	}
+	var external *External
+	named := map[string]AQ{}
+	for k, v := range raw {
+		if k == EngineKey {
+			external = &External{}
+			if err := json.Unmarshal(v, external); err != nil {
+				return nil, nil, fmt.Errorf("%s.json: %s: %w", name, k, err)
+			}
+			if external.Command == "" {
+				return nil, nil, fmt.Errorf("%s.json: %s: command is empty", name, k)
+			}
+			continue
+		}
+		var aq AQ
+		if err := json.Unmarshal(v, &aq); err != nil {
+			return nil, nil, fmt.Errorf("%s.json: %s: %w", name, k, err)
+		}
+		named[k] = aq
+	}
+	if external != nil {
+		for k, aq := range dict {
+			aq.Text = ""
+			dict[k] = aq
+		}
+	}
+	for k, aq := range named {
+		dict[k] = aq
+	}
+	return dict, external, nil
+}
+
+func (e *Engine) makeAudioQuery(text string) (nanoda.AudioQuery, error) {
+	o := e.opts
+	q, err := e.synthesizer.CreateAudioQuery(text, nanoda.StyleId(o.ActorID))
 	if err != nil {
 		return nanoda.AudioQuery{}, err
 	}
-	q.IntonationScale = Intnation
-	q.PitchScale = Pitch
-	q.SpeedScale = Speed
-	q.VolumeScale = Volume
-	q.PrePhonemeLength = PrePhonemeLength
-	q.PostPhonemeLength = PostPhonemeLength
+	q.IntonationScale = o.Intnation
+	q.PitchScale = o.Pitch
+	q.SpeedScale = o.Speed
+	q.VolumeScale = o.Volume
+	q.PrePhonemeLength = o.PrePhonemeLength
+	q.PostPhonemeLength = o.PostPhonemeLength
 	for _, p := range q.AccentPhrases[1:] {
 		if p.PauseMora != nil {
-			p.PauseMora.VowelLength *= Pause
+			p.PauseMora.VowelLength *= o.Pause
 		}
 	}
 	return q, nil
 }
 
-func Init(s nanoda.Synthesizer, dict map[string]AQ) (map[string]nanoda.AudioQuery, error) {
-	res := map[string]nanoda.AudioQuery{}
+func (e *Engine) compile(dict map[string]AQ) (AudioDict, error) {
+	res := AudioDict{}
 	for k, v := range dict {
-		q, err := makeAudioQuery(s, v.Text)
+		q, err := e.makeAudioQuery(v.Text)
 		if err != nil {
 			return nil, err
 		}
@@ -152,3 +222,29 @@ func Init(s nanoda.Synthesizer, dict map[string]AQ) (map[string]nanoda.AudioQuer
 	}
 	return res, nil
 }
+
+// StageDict はステージのペースノート文言を辞書化する。外部エンジンの辞書を使用中なら何もしない。
+func (e *Engine) StageDict(messages []string) AudioDict {
+	d := AudioDict{}
+	if e.current().external != nil {
+		return d
+	}
+	for _, s := range messages {
+		if _, ok := d[s]; ok {
+			continue
+		}
+		q, err := e.makeAudioQuery(s)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		d[s] = q
+	}
+	return d
+}
+
+func (e *Engine) SetDict(d AudioDict) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stageDict = d
+}
@@ -0,0 +1,63 @@
+package ttsengine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadNamedDictionary(t *testing.T) {
+	dir := t.TempDir()
+	fpath := filepath.Join(dir, "dictionary.json")
+	if err := os.WriteFile(fpath, []byte(`{"crest": {"text": "クレスト", "speed": 1.5}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	named := map[string]string{
+		"kana":    `{"3-left": {"text": "さんひだり"}}`,
+		"english": `{"$engine": {"command": "espeak"}, "3-left": {"text": "three left", "speed": 1.2}}`,
+		"broken":  `{"$engine": {"args": ["{text}"]}}`,
+	}
+	for name, content := range named {
+		if err := os.WriteFile(filepath.Join(dir, name+".json"), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dict, external, err := LoadNamedDictionary(fpath, dir, "kana")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if external != nil || dict["3-left"].Text != "さんひだり" || dict["crest"].Text != "クレスト" {
+		t.Errorf("kana: external = %v, 3-left = %+v, crest = %+v", external, dict["3-left"], dict["crest"])
+	}
+
+	dict, external, err = LoadNamedDictionary(fpath, dir, "english")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if external == nil || external.Command != "espeak" {
+		t.Fatalf("external = %+v", external)
+	}
+	d := &voiceDict{words: dict, external: external}
+	tests := []struct {
+		word string
+		want AQ
+	}{
+		{"3-left", AQ{Text: "three left", Speed: 1.2}},
+		{"crest", AQ{Text: "crest", Speed: 1.5}}, // dictionary.json の読みは使わない
+		{"3-right", AQ{Text: "3-right"}},         // base.json の読みも使わない
+		{"unlisted", AQ{Text: "unlisted"}},
+	}
+	for _, tt := range tests {
+		if got := d.externalAQ(tt.word); got != tt.want {
+			t.Errorf("externalAQ(%q) = %+v, want %+v", tt.word, got, tt.want)
+		}
+	}
+
+	if _, _, err := LoadNamedDictionary(fpath, dir, "broken"); err == nil {
+		t.Error("engine without command was accepted")
+	}
+	if _, _, err := LoadNamedDictionary(fpath, dir, "../kana"); err == nil {
+		t.Error("invalid name was accepted")
+	}
+}